@@ -0,0 +1,185 @@
+package wifi
+
+import (
+	"sync"
+	"time"
+)
+
+// Merges the DataPoints of a device over a window into a single one, with a
+// Summary of the frames. Windows are in capture time, so replaying a capture
+// file aggregates just like listening did.
+type Aggregator struct {
+	Window time.Duration
+	mtx    sync.Mutex
+	open   map[string]*aggregate
+	// by start, emitted ones are skipped
+	order []*aggregate
+	// the newest capture time seen, and when
+	latest, seen time.Time
+}
+
+type aggregate struct {
+	dev   *Device
+	start time.Time
+	// of the signals, for the mean
+	sum  float64
+	done bool
+}
+
+func NewAggregator(window time.Duration) *Aggregator {
+	return &Aggregator{Window: window, open: map[string]*aggregate{}}
+}
+
+// Take <dev> over, the devices returned are those whose window ended,
+// oldest first.
+func (a *Aggregator) Add(dev *Device) (done []*Device) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	for _, dp := range dev.GetDataPoints() {
+		stamp := time.Unix(0, int64(dp.TimeStamp))
+		agg := a.open[dev.MAC]
+		if agg != nil && stamp.Sub(agg.start) >= a.Window {
+			done = append(done, a.close(agg))
+			agg = nil
+		}
+		if agg == nil {
+			agg = &aggregate{
+				dev: &Device{
+					MAC:        dev.MAC,
+					Randomized: dev.Randomized,
+				},
+				start: stamp,
+			}
+			a.open[dev.MAC] = agg
+			a.order = append(a.order, agg)
+		}
+		agg.merge(dev, dp)
+		if stamp.After(a.latest) {
+			a.latest, a.seen = stamp, time.Now()
+		}
+	}
+	return append(done, a.expire(a.latest)...)
+}
+
+// the devices whose window ended by now, in capture time
+func (a *Aggregator) Expire() []*Device {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.latest.IsZero() {
+		return nil
+	}
+	return a.expire(a.latest.Add(time.Since(a.seen)))
+}
+
+// all devices, windows ended or not
+func (a *Aggregator) Flush() (done []*Device) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	for _, agg := range a.order {
+		if !agg.done {
+			done = append(done, a.close(agg))
+		}
+	}
+	a.order = nil
+	return
+}
+
+func (a *Aggregator) expire(now time.Time) (done []*Device) {
+	for len(a.order) > 0 {
+		agg := a.order[0]
+		if !agg.done {
+			if now.Sub(agg.start) < a.Window {
+				break
+			}
+			done = append(done, a.close(agg))
+		}
+		a.order = a.order[1:]
+	}
+	return
+}
+
+func (a *Aggregator) close(agg *aggregate) *Device {
+	agg.done = true
+	delete(a.open, agg.dev.MAC)
+	sum := agg.dev.DataPoints[0].Summary
+	sum.MeanSignal = float32(agg.sum / float64(sum.Frames))
+	return agg.dev
+}
+
+// workers may hand in frames slightly out of order
+func (agg *aggregate) merge(dev *Device, dp *DataPoint) {
+	signal := int32(int8(dp.Signal))
+	stamp := dp.TimeStamp
+	var sum *Summary
+	if len(agg.dev.DataPoints) == 0 {
+		sum = &Summary{MinSignal: signal, MaxSignal: signal, FirstSeen: stamp}
+	} else {
+		sum = agg.dev.DataPoints[0].Summary
+	}
+	if stamp >= sum.LastSeen {
+		dp.Summary = sum
+		agg.dev.DataPoints = []*DataPoint{dp}
+	}
+	sum.Frames++
+	if signal < sum.MinSignal {
+		sum.MinSignal = signal
+	}
+	if signal > sum.MaxSignal {
+		sum.MaxSignal = signal
+	}
+	if stamp > sum.LastSeen {
+		sum.LastSeen = stamp
+	}
+	if stamp < sum.FirstSeen {
+		sum.FirstSeen = stamp
+	}
+	agg.sum += float64(signal)
+	if dev.Fingerprint != nil {
+		agg.dev.Fingerprint = mergeFingerprints(agg.dev.Fingerprint, dev.Fingerprint)
+	}
+	if dev.PseudoID != "" {
+		agg.dev.PseudoID = dev.PseudoID
+	}
+}
+
+// the capabilities of <fp>, the SSIDs and vendor OUIs of both, <old> may be
+// nil. Neither is modified, they are part of the devices handed in.
+func mergeFingerprints(old, fp *Fingerprint) *Fingerprint {
+	merged := &Fingerprint{
+		Rates:           fp.Rates,
+		HTCapabilities:  fp.HTCapabilities,
+		VHTCapabilities: fp.VHTCapabilities,
+		HECapabilities:  fp.HECapabilities,
+		ExtCapabilities: fp.ExtCapabilities,
+		IEIDs:           fp.IEIDs,
+	}
+	for _, ssid := range append(old.GetSSIDs(), fp.SSIDs...) {
+		if !containsString(merged.SSIDs, ssid) {
+			merged.SSIDs = append(merged.SSIDs, ssid)
+		}
+	}
+	for _, oui := range append(old.GetVendorOUIs(), fp.VendorOUIs...) {
+		if !containsUint32(merged.VendorOUIs, oui) {
+			merged.VendorOUIs = append(merged.VendorOUIs, oui)
+		}
+	}
+	return merged
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint32(us []uint32, u uint32) bool {
+	for _, x := range us {
+		if x == u {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package wifi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func sighting(mac string, stamp time.Duration, signal int8) *Device {
+	return &Device{MAC: mac, DataPoints: []*DataPoint{
+		{TimeStamp: uint64(stamp), Signal: uint32(signal), Sequence: uint32(stamp / time.Second)},
+	}}
+}
+
+func TestAggregator(t *testing.T) {
+	a := NewAggregator(10 * time.Second)
+	phone, watch := "02:00:00:00:00:01", "02:00:00:00:00:02"
+	var done []*Device
+	for _, dev := range []*Device{
+		sighting(phone, 1*time.Second, -40),
+		sighting(watch, 2*time.Second, -70),
+		sighting(phone, 3*time.Second, -80),
+		// out of order, from another worker
+		sighting(phone, 2*time.Second, -60),
+		sighting(phone, 5*time.Second, -60),
+	} {
+		done = append(done, a.Add(dev)...)
+	}
+	if len(done) != 0 {
+		t.Fatalf("window not over yet: %v", done)
+	}
+	// the watch's window is over, the phone's starts anew
+	done = a.Add(sighting(phone, 12*time.Second, -50))
+	if len(done) != 2 || done[0].MAC != phone || done[1].MAC != watch {
+		t.Fatalf("got %v", done)
+	}
+	dp := done[0].DataPoints[0]
+	want := Summary{Frames: 4, MinSignal: -80, MaxSignal: -40, MeanSignal: -60,
+		FirstSeen: uint64(time.Second), LastSeen: uint64(5 * time.Second)}
+	if len(done[0].DataPoints) != 1 || dp.Summary.String() != want.String() {
+		t.Errorf("got %v, want %v", dp.Summary, &want)
+	}
+	if dp.Sequence != 5 {
+		t.Errorf("not the last frame: %v", dp)
+	}
+	if sum := done[1].DataPoints[0].Summary; sum.Frames != 1 || sum.MeanSignal != -70 {
+		t.Errorf("got %v", sum)
+	}
+
+	if done = a.Expire(); len(done) != 0 {
+		t.Errorf("expired early: %v", done)
+	}
+	done = a.Flush()
+	if len(done) != 1 || done[0].DataPoints[0].Summary.Frames != 1 {
+		t.Errorf("got %v", done)
+	}
+	if done = a.Flush(); len(done) != 0 {
+		t.Errorf("flushed twice: %v", done)
+	}
+}
+
+func TestAggregatorMergesFingerprints(t *testing.T) {
+	a := NewAggregator(10 * time.Second)
+	phone := "02:00:00:00:00:01"
+	first, second := sighting(phone, time.Second, -40), sighting(phone, 2*time.Second, -40)
+	first.Fingerprint = &Fingerprint{SSIDs: []string{"home"}, VendorOUIs: []uint32{0x0050f204}}
+	second.Fingerprint = &Fingerprint{SSIDs: []string{"work", "home"}, VendorOUIs: []uint32{0x00101802}}
+	a.Add(first)
+	a.Add(second)
+	done := a.Flush()
+	if len(done) != 1 {
+		t.Fatalf("got %v", done)
+	}
+	fp := done[0].Fingerprint
+	if len(fp.SSIDs) != 2 || fp.SSIDs[0] != "home" || fp.SSIDs[1] != "work" || len(fp.VendorOUIs) != 2 {
+		t.Errorf("got %v", fp)
+	}
+	if len(second.Fingerprint.SSIDs) != 2 {
+		t.Errorf("modified the device handed in: %v", second.Fingerprint)
+	}
+}
+
+func TestListenAggregates(t *testing.T) {
+	src := &replaySource{frames: mixedFrames(t), n: 100}
+	// all in one window, the stamps are nanoseconds apart
+	w := NewWifi(WifiConfig{Source: src, Workers: 4, DisableLinker: true, Aggregate: time.Hour})
+	frames := map[string]uint32{}
+	for dev := range w.Start(context.Background()) {
+		if _, ok := frames[dev.MAC]; ok {
+			t.Errorf("%s twice", dev.MAC)
+		}
+		frames[dev.MAC] = dev.DataPoints[0].Summary.GetFrames()
+	}
+	// the probe requests of two stations, and the data frames of one of them
+	if len(frames) != 2 || frames["00:1b:63:00:00:01"] != 4*uint32(src.n) || frames["02:00:00:00:00:01"] != uint32(src.n) {
+		t.Errorf("got %v", frames)
+	}
+}
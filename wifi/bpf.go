@@ -0,0 +1,209 @@
+package wifi
+
+import (
+	"fmt"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/bpf"
+	"net"
+	"strings"
+)
+
+// what the kernel passes on, more than any 802.11 frame
+const bpfSnapLen = 0x40000
+
+// 802.11 frame control, first byte: subtype(4) type(2) version(2)
+const (
+	fcBeacon      = 0x80
+	fcProbeReq    = 0x40
+	fcTypeMask    = 0x0c
+	fcTypeControl = 0x04
+	// second byte
+	fcFlagsDS = 0x03
+	fcToDS    = 0x01
+	fcFromDS  = 0x02
+)
+
+// offsets of the addresses in the 802.11 header
+const (
+	dot11Address1 = 4
+	dot11Address2 = 10
+)
+
+// A classic BPF program for radiotap captures, dropping in the kernel what
+// Listen would drop anyways: beacons, control frames, frames ToDevice finds
+// no address in and addresses ShouldIgnore.
+//
+// Keep in sync with InterestingLayers.filter, ToDevice and IgnoreEUIs.
+func BPFProgram() ([]bpf.Instruction, error) {
+	var a bpfAsm
+	// X = radiotap header length, little endian
+	a.emit(bpf.LoadAbsolute{Off: 3, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftLeft, Val: 8},
+		bpf.TAX{},
+		bpf.LoadAbsolute{Off: 2, Size: 1},
+		bpf.ALUOpX{Op: bpf.ALUOpAdd},
+		bpf.TAX{})
+
+	a.emit(bpf.LoadIndirect{Off: 0, Size: 1})
+	a.jumpIf(bpf.JumpEqual, fcBeacon, "reject", "")
+	a.emit(bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: fcTypeMask})
+	a.jumpIf(bpf.JumpEqual, fcTypeControl, "reject", "")
+
+	a.emit(bpf.LoadIndirect{Off: 1, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: fcFlagsDS})
+	a.jumpIf(bpf.JumpEqual, fcToDS, "sa", "")
+	a.jumpIf(bpf.JumpEqual, fcFromDS, "da", "")
+	// WDS
+	a.jumpIf(bpf.JumpEqual, fcToDS|fcFromDS, "reject", "")
+	// no DS, only probe requests have a station as sender
+	a.emit(bpf.LoadIndirect{Off: 0, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xfc})
+	a.jumpIf(bpf.JumpEqual, fcProbeReq, "sa", "reject")
+
+	a.label("sa")
+	if err := a.ignoreAddresses(dot11Address2); err != nil {
+		return nil, err
+	}
+	a.jump("accept")
+	a.label("da")
+	if err := a.ignoreAddresses(dot11Address1); err != nil {
+		return nil, err
+	}
+
+	a.label("accept")
+	a.emit(bpf.RetConstant{Val: bpfSnapLen})
+	a.label("reject")
+	a.emit(bpf.RetConstant{Val: 0})
+	return a.assemble()
+}
+
+// jump to "reject" for addresses at <off> matching IgnoreEUIs
+func (a *bpfAsm) ignoreAddresses(off uint32) error {
+	for _, reason := range IgnoreEUIs {
+		addr, err := net.ParseMAC(reason.AddrRange[0])
+		if err != nil {
+			return fmt.Errorf("IgnoreEUIs %s: %v", reason.Reason, err)
+		}
+		// see IgnoreEUIReason.Matches
+		n := len(addr)
+		if reason.AddrRange[1] != "" {
+			n = strings.Index(reason.AddrRange[1], "ff") / 3
+		}
+		if n == 0 || n == 5 {
+			return fmt.Errorf("IgnoreEUIs %s: %d byte prefix unsupported", reason.Reason, n)
+		}
+		next := a.local()
+		var word uint32
+		for i := 0; i < 4; i++ {
+			word <<= 8
+			if i < n {
+				word |= uint32(addr[i])
+			}
+		}
+		a.emit(bpf.LoadIndirect{Off: off, Size: 4})
+		if n < 4 {
+			a.emit(bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: ^uint32(0) << uint(8*(4-n))})
+		}
+		if n <= 4 {
+			a.jumpIf(bpf.JumpEqual, word, "reject", "")
+			continue
+		}
+		a.jumpIf(bpf.JumpEqual, word, "", next)
+		a.emit(bpf.LoadIndirect{Off: off + 4, Size: 2})
+		a.jumpIf(bpf.JumpEqual, uint32(addr[4])<<8|uint32(addr[5]), "reject", "")
+		a.label(next)
+	}
+	return nil
+}
+
+// classic BPF only jumps forward, by instruction counts. This lets us jump
+// to labels instead, "" is the next instruction.
+type bpfAsm struct {
+	prog   []bpf.Instruction
+	labels map[string]int
+	jumps  []bpfJump
+	nlocal int
+}
+
+type bpfJump struct {
+	at     int
+	jt, jf string
+}
+
+func (a *bpfAsm) emit(ins ...bpf.Instruction) {
+	a.prog = append(a.prog, ins...)
+}
+
+func (a *bpfAsm) label(name string) {
+	if a.labels == nil {
+		a.labels = map[string]int{}
+	}
+	a.labels[name] = len(a.prog)
+}
+
+// a fresh label name
+func (a *bpfAsm) local() string {
+	a.nlocal++
+	return fmt.Sprintf(".%d", a.nlocal)
+}
+
+func (a *bpfAsm) jumpIf(cond bpf.JumpTest, val uint32, jt, jf string) {
+	a.jumps = append(a.jumps, bpfJump{len(a.prog), jt, jf})
+	a.emit(bpf.JumpIf{Cond: cond, Val: val})
+}
+
+func (a *bpfAsm) jump(to string) {
+	a.jumps = append(a.jumps, bpfJump{at: len(a.prog), jt: to})
+	a.emit(bpf.Jump{})
+}
+
+func (a *bpfAsm) skip(from int, to string) (uint32, error) {
+	if to == "" {
+		return 0, nil
+	}
+	target, ok := a.labels[to]
+	if !ok {
+		return 0, fmt.Errorf("bpf: unknown label %s", to)
+	}
+	if target <= from {
+		return 0, fmt.Errorf("bpf: jump backwards to %s", to)
+	}
+	return uint32(target - from - 1), nil
+}
+
+func (a *bpfAsm) assemble() ([]bpf.Instruction, error) {
+	for _, j := range a.jumps {
+		jt, err := a.skip(j.at, j.jt)
+		if err != nil {
+			return nil, err
+		}
+		jf, err := a.skip(j.at, j.jf)
+		if err != nil {
+			return nil, err
+		}
+		if jt > 0xff || jf > 0xff {
+			return nil, fmt.Errorf("bpf: jump at %d too far", j.at)
+		}
+		switch ins := a.prog[j.at].(type) {
+		case bpf.JumpIf:
+			ins.SkipTrue, ins.SkipFalse = uint8(jt), uint8(jf)
+			a.prog[j.at] = ins
+		case bpf.Jump:
+			a.prog[j.at] = bpf.Jump{Skip: jt}
+		}
+	}
+	return a.prog, nil
+}
+
+// what pcap.Handle.SetBPFInstructionFilter takes
+func pcapBPF(prog []bpf.Instruction) ([]pcap.BPFInstruction, error) {
+	raw, err := bpf.Assemble(prog)
+	if err != nil {
+		return nil, err
+	}
+	ins := make([]pcap.BPFInstruction, len(raw))
+	for i, r := range raw {
+		ins[i] = pcap.BPFInstruction{Code: r.Op, Jt: r.Jt, Jf: r.Jf, K: r.K}
+	}
+	return ins, nil
+}
@@ -0,0 +1,122 @@
+package wifi
+
+import (
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+	"net"
+	"testing"
+)
+
+func mac(t testing.TB, s string) net.HardwareAddr {
+	addr, err := net.ParseMAC(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+// radiotap with frequency and signal, then <dot11> and a few bytes
+func frame(t testing.TB, dot11 *layers.Dot11) []byte {
+	rt := []byte{0, 0, 13, 0, 0x28, 0, 0, 0, 0, 0, 0xa0, 0, 0xc4}
+	binary.LittleEndian.PutUint16(rt[8:], 2412)
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, dot11, gopacket.Payload{0xaa, 0xaa, 3, 0, 0, 0, 8, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(rt, buf.Bytes()...)
+}
+
+// what Listen makes of it
+func userspaceKeeps(data []byte) bool {
+	ils := NewInterestingLayers(gopacket.NewPacket(data, layers.LayerTypeRadioTap, gopacket.Default))
+	if ils == nil || ils.filter() {
+		return false
+	}
+	_, addr := ils.ToDevice()
+	ignore, _ := ShouldIgnore(addr)
+	return !ignore
+}
+
+func TestBPFProgramMatchesUserspace(t *testing.T) {
+	prog, err := BPFProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := bpf.NewVM(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pcapBPF(prog); err != nil {
+		t.Fatal(err)
+	}
+	sta, ap := mac(t, "00:1b:63:00:00:01"), mac(t, "00:1b:63:00:00:02")
+	bcast := layers.EthernetBroadcast
+	for _, tc := range []struct {
+		name  string
+		dot11 layers.Dot11
+		keep  bool
+	}{
+		{"probe request", layers.Dot11{Type: layers.Dot11TypeMgmtProbeReq, Address1: bcast, Address2: sta, Address3: bcast}, true},
+		{"probe request, randomized", layers.Dot11{Type: layers.Dot11TypeMgmtProbeReq, Address1: bcast, Address2: mac(t, "02:00:00:00:00:01"), Address3: bcast}, true},
+		{"probe request, zero", layers.Dot11{Type: layers.Dot11TypeMgmtProbeReq, Address1: bcast, Address2: mac(t, "00:00:00:00:00:00"), Address3: bcast}, false},
+		{"probe request, almost zero", layers.Dot11{Type: layers.Dot11TypeMgmtProbeReq, Address1: bcast, Address2: mac(t, "00:00:00:00:00:01"), Address3: bcast}, true},
+		{"beacon", layers.Dot11{Type: layers.Dot11TypeMgmtBeacon, Address1: bcast, Address2: ap, Address3: ap}, false},
+		{"probe response", layers.Dot11{Type: layers.Dot11TypeMgmtProbeResp, Address1: sta, Address2: ap, Address3: ap}, false},
+		{"ack", layers.Dot11{Type: layers.Dot11TypeCtrlAck, Address1: sta}, false},
+		{"rts", layers.Dot11{Type: layers.Dot11TypeCtrlRTS, Address1: ap, Address2: sta}, false},
+		{"data to ap", layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsToDS, Address1: ap, Address2: sta, Address3: ap}, true},
+		{"data to ap, ipv6 multicast", layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsToDS, Address1: ap, Address2: mac(t, "33:33:00:00:00:01"), Address3: ap}, false},
+		{"data from ap", layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsFromDS, Address1: sta, Address2: ap, Address3: ap}, true},
+		{"data from ap, multicast", layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsFromDS, Address1: mac(t, "01:00:5e:00:00:fb"), Address2: ap, Address3: ap}, false},
+		{"data from ap, broadcast", layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsFromDS, Address1: bcast, Address2: ap, Address3: ap}, false},
+		{"data from ap, unicast prefix", layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsFromDS, Address1: mac(t, "00:00:5e:00:01:01"), Address2: ap, Address3: ap}, false},
+		{"wds", layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsToDS | layers.Dot11FlagsFromDS, Address1: ap, Address2: ap, Address3: sta, Address4: sta}, false},
+		{"ibss data", layers.Dot11{Type: layers.Dot11TypeData, Address1: sta, Address2: ap, Address3: ap}, false},
+	} {
+		data := frame(t, &tc.dot11)
+		if userspaceKeeps(data) != tc.keep {
+			t.Errorf("%s: userspace keep=%v, test is wrong", tc.name, !tc.keep)
+			continue
+		}
+		n, err := vm.Run(data)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if (n != 0) != tc.keep {
+			t.Errorf("%s: kernel keep=%v, userspace keep=%v", tc.name, n != 0, tc.keep)
+		}
+	}
+
+	// too short to tell, userspace can't decode it either
+	if n, _ := vm.Run([]byte{0, 0, 13, 0}); n != 0 {
+		t.Errorf("truncated frame kept")
+	}
+}
+
+func TestBPFAsm(t *testing.T) {
+	var a bpfAsm
+	a.jumpIf(bpf.JumpEqual, 1, "one", "")
+	a.jump("end")
+	a.label("one")
+	a.emit(bpf.RetConstant{Val: 1})
+	a.label("end")
+	a.emit(bpf.RetConstant{Val: 0})
+	prog, err := a.assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j := prog[0].(bpf.JumpIf); j.SkipTrue != 1 || j.SkipFalse != 0 {
+		t.Errorf("got %+v", j)
+	}
+	if j := prog[1].(bpf.Jump); j.Skip != 1 {
+		t.Errorf("got %+v", j)
+	}
+
+	a.jump("nowhere")
+	if _, err = a.assemble(); err == nil {
+		t.Error("unknown label accepted")
+	}
+}
@@ -0,0 +1,231 @@
+package wifi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+type Band uint8
+
+// can be or'ed together to select multiple bands
+const (
+	Band2GHz Band = 1 << iota
+	Band5GHz
+	Band6GHz
+)
+
+var bandNames = []string{"2.4GHz", "5GHz", "6GHz"}
+
+func (b Band) String() string {
+	var names []string
+	for i, name := range bandNames {
+		if b&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// values match enum nl80211_chan_width
+type ChannelWidth int
+
+const (
+	Width20NoHT ChannelWidth = 0
+	Width20     ChannelWidth = 1
+	Width40     ChannelWidth = 2
+	Width80     ChannelWidth = 3
+	Width160    ChannelWidth = 5
+)
+
+func (w ChannelWidth) MHz() int {
+	switch w {
+	case Width40:
+		return 40
+	case Width80:
+		return 80
+	case Width160:
+		return 160
+	}
+	return 20
+}
+
+type ChannelSpec struct {
+	Band    Band
+	Channel int
+	Width   ChannelWidth
+	// center frequency of the whole (bonded) channel in MHz, derived if 0
+	Center int
+}
+
+func (c ChannelSpec) String() string {
+	return fmt.Sprintf("%d(%dMHz,%dMHz wide)", c.Channel, c.Freq(), c.Width.MHz())
+}
+
+// frequency of the primary 20MHz channel in MHz, 0 if invalid
+func (c ChannelSpec) Freq() int {
+	return ChannelToFrequency(c.Band, c.Channel)
+}
+
+// center frequency of the whole channel, for 40MHz on 2.4GHz the secondary
+// channel is choosen above the primary where possible. An error if the width
+// is not possible in the band, or Center doesn't cover the primary channel.
+func (c ChannelSpec) CenterFreq() (int, error) {
+	freq, width := c.Freq(), c.Width.MHz()
+	if freq == 0 {
+		return 0, fmt.Errorf("invalid channel %d for band %v", c.Channel, c.Band)
+	}
+	if c.Band == Band2GHz && width > 40 {
+		return 0, fmt.Errorf("no %dMHz wide channels on %v", width, c.Band)
+	}
+	if c.Center != 0 {
+		// the primary is one of the 20MHz channels within the bonded one
+		off := freq - c.Center
+		if off < 0 {
+			off = -off
+		}
+		if off > width/2-10 || off%10 != 0 {
+			return 0, fmt.Errorf("center %dMHz doesn't fit %v", c.Center, c)
+		}
+		return c.Center, nil
+	}
+	if width == 20 {
+		return freq, nil
+	}
+	n := width / 20
+	base, chs := 1, channels6GHz
+	switch c.Band {
+	case Band2GHz:
+		if c.Channel <= 7 {
+			return freq + 10, nil
+		}
+		return freq - 10, nil
+	case Band5GHz:
+		base, chs = 36, channels5GHz
+		if c.Channel >= 149 {
+			base = 149
+		}
+	}
+	center := bondedCenter(base, c.Channel, n)
+	// e.g. 165 has no neighbours to bond with
+	for ch := center - 2*(n-1); ch <= center+2*(n-1); ch += 4 {
+		if !containsInt(chs, ch) {
+			return 0, fmt.Errorf("no %dMHz wide channel around %v", width, c)
+		}
+	}
+	return ChannelToFrequency(c.Band, center), nil
+}
+
+// channels are numbered in steps of 4 starting at <base>, the bonded channel
+// of <n> 20MHz channels is aligned to a multiple of <n>
+func bondedCenter(base, channel, n int) int {
+	block := (channel - base) / 4 / n
+	return base + block*4*n + 2*(n-1)
+}
+
+func containsInt(list []int, i int) bool {
+	for _, e := range list {
+		if e == i {
+			return true
+		}
+	}
+	return false
+}
+
+// IEEE 802.11 channel number to MHz, 0 if unknown
+func ChannelToFrequency(band Band, channel int) int {
+	switch band {
+	case Band2GHz:
+		if channel == 14 {
+			return 2484
+		}
+		if channel >= 1 && channel <= 13 {
+			return 2407 + 5*channel
+		}
+	case Band5GHz:
+		if channel >= 1 && channel <= 196 {
+			return 5000 + 5*channel
+		}
+	case Band6GHz:
+		if channel == 2 {
+			return 5935
+		}
+		if channel >= 1 && channel <= 233 {
+			return 5950 + 5*channel
+		}
+	}
+	return 0
+}
+
+// MHz to band + IEEE 802.11 channel number, channel is 0 if unknown
+func FrequencyToChannel(freq int) (Band, int) {
+	switch {
+	case freq == 2484:
+		return Band2GHz, 14
+	case freq >= 2412 && freq <= 2472:
+		return Band2GHz, (freq - 2407) / 5
+	case freq == 5935:
+		return Band6GHz, 2
+	case freq >= 5955 && freq <= 7115:
+		return Band6GHz, (freq - 5950) / 5
+	case freq >= 5005 && freq <= 5925:
+		return Band5GHz, (freq - 5000) / 5
+	}
+	return 0, 0
+}
+
+type ChannelSetter interface {
+	// tune the interface <ifname> to <spec>
+	SetChannel(ifname string, spec ChannelSpec) error
+}
+
+// tries each setter in order, until one succeeds
+type FallbackSetter []ChannelSetter
+
+func (fs FallbackSetter) SetChannel(ifname string, spec ChannelSpec) error {
+	var errs []string
+	for _, setter := range fs {
+		err := setter.SetChannel(ifname, spec)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == 0 {
+		return errors.New("no channel setter available")
+	}
+	return errors.New(strings.Join(errs, ", "))
+}
+
+// set by channel_wext.go, iff build with cgo
+var wextSetter ChannelSetter
+
+var (
+	defaultSetter     ChannelSetter
+	defaultSetterOnce sync.Once
+)
+
+// nl80211, with the wireless extensions as fallback if available
+func DefaultChannelSetter() ChannelSetter {
+	defaultSetterOnce.Do(func() {
+		var fs FallbackSetter
+		nl, err := NewNL80211()
+		if err != nil {
+			log.Printf("nl80211 unavailable: %v", err)
+		} else {
+			fs = append(fs, nl)
+		}
+		if wextSetter != nil {
+			fs = append(fs, wextSetter)
+		}
+		defaultSetter = fs
+	})
+	return defaultSetter
+}
+
+// set the 2.4GHz channel for the interface <ifi> to <ch>
+func SetChannel(ifi string, ch int) error {
+	return DefaultChannelSetter().SetChannel(ifi, ChannelSpec{Band: Band2GHz, Channel: ch})
+}
@@ -0,0 +1,43 @@
+package wifi
+
+import (
+	"testing"
+)
+
+func TestChannelFrequencies(t *testing.T) {
+	cases := []struct {
+		spec   ChannelSpec
+		freq   int
+		center int // 0 if not possible
+	}{
+		{ChannelSpec{Band: Band2GHz, Channel: 1}, 2412, 2412},
+		{ChannelSpec{Band: Band2GHz, Channel: 14}, 2484, 2484},
+		{ChannelSpec{Band: Band2GHz, Channel: 11, Width: Width40}, 2462, 2452},
+		{ChannelSpec{Band: Band5GHz, Channel: 36, Width: Width80}, 5180, 5210},
+		{ChannelSpec{Band: Band5GHz, Channel: 64, Width: Width80}, 5320, 5290},
+		{ChannelSpec{Band: Band5GHz, Channel: 100, Width: Width160}, 5500, 5570},
+		{ChannelSpec{Band: Band5GHz, Channel: 153, Width: Width40}, 5765, 5755},
+		{ChannelSpec{Band: Band5GHz, Channel: 161, Width: Width80}, 5805, 5775},
+		{ChannelSpec{Band: Band6GHz, Channel: 37, Width: Width160}, 6135, 6185},
+		{ChannelSpec{Band: Band6GHz, Channel: 5, Width: Width40, Center: 5985}, 5975, 5985},
+		{ChannelSpec{Band: Band6GHz, Channel: 5, Width: Width20, Center: 5980}, 5975, 0},
+		{ChannelSpec{Band: Band6GHz, Channel: 5, Width: Width80, Center: 6095}, 5975, 0},
+		{ChannelSpec{Band: Band2GHz, Channel: 6, Width: Width80}, 2437, 0},
+		{ChannelSpec{Band: Band2GHz, Channel: 6, Width: Width160}, 2437, 0},
+		{ChannelSpec{Band: Band5GHz, Channel: 165, Width: Width80}, 5825, 0},
+		{ChannelSpec{Band: Band5GHz, Channel: 144, Width: Width160}, 5720, 0},
+	}
+	for _, c := range cases {
+		if f := c.spec.Freq(); f != c.freq {
+			t.Errorf("%v: freq %d, expected %d", c.spec, f, c.freq)
+		}
+		f, err := c.spec.CenterFreq()
+		if f != c.center || (err != nil) != (c.center == 0) {
+			t.Errorf("%v: center %d, %v, expected %d", c.spec, f, err, c.center)
+		}
+		band, ch := FrequencyToChannel(c.freq)
+		if band != c.spec.Band || ch != c.spec.Channel {
+			t.Errorf("%d: got %v/%d, expected %v/%d", c.freq, band, ch, c.spec.Band, c.spec.Channel)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+//go:build cgo
+// +build cgo
+
+package wifi
+
+/*
+#include <stdlib.h>
+#include <unistd.h>
+#include <errno.h>
+#include <string.h>
+#include <sys/ioctl.h>
+#include <sys/socket.h>
+#include <linux/wireless.h>
+#include <arpa/inet.h>
+#include <linux/if_ether.h>
+
+const char ErrInvalidArg[] = "Invalid argument";
+
+// return NULL on success, on error the result of 'Man strerror'
+// TODO: pass iw struct + connected socket as argument
+const char *
+set_freq(const char *iface, int freq_mhz)
+{
+  struct iwreq iw;
+  size_t iface_len;
+  int fd, r;
+
+  if (!iface || (iface_len = strlen(iface)) > IFNAMSIZ)
+    return ErrInvalidArg;
+
+  strncpy(iw.ifr_ifrn.ifrn_name, iface, iface_len);
+
+  fd = socket(PF_PACKET, SOCK_RAW, htons(ETH_P_ALL));
+  if (fd < 0)
+    return strerror(errno);
+
+  iw.u.freq = (struct iw_freq){
+      // Note: the 'freq' field is overloaded with frequency + channel
+      // -> low numbers N<=1000 are encoded as e(xponent)=0 and m(antissa)=N,
+      // we use the frequency, channel numbers are ambiguous since 6GHz
+      .m = freq_mhz,
+      .e = 6};
+  r = ioctl(fd, SIOCSIWFREQ, &iw);
+  close(fd);
+  if (r == -1)
+    return strerror(errno);
+  return NULL;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+func init() {
+	wextSetter = wext{}
+}
+
+// the deprecated wireless extensions (SIOCSIWFREQ), 20MHz channels only
+type wext struct{}
+
+func (wext) SetChannel(ifi string, spec ChannelSpec) error {
+	if spec.Width.MHz() != 20 {
+		return errors.New("wext: only 20MHz channels supported")
+	}
+	if spec.Freq() == 0 {
+		return errors.New("wext: invalid channel")
+	}
+	cifi := C.CString(ifi)
+	defer C.free(unsafe.Pointer(cifi))
+	cp := C.set_freq(cifi, C.int(spec.Freq()))
+	if cp != nil {
+		return errors.New(C.GoString(cp))
+	}
+	return nil
+}
@@ -1,89 +1,233 @@
 package wifi
 
-/*
-#include <stdlib.h>
-#include <unistd.h>
-#include <errno.h>
-#include <string.h>
-#include <sys/ioctl.h>
-#include <sys/socket.h>
-#include <linux/wireless.h>
-#include <arpa/inet.h>
-#include <linux/if_ether.h>
-
-const char ErrInvalidArg[] = "Invalid argument";
-
-// return NULL on success, on error the result of 'Man strerror'
-// TODO: pass iw struct + connected socket as argument
-const char *
-set_channel(const char *iface, int channel)
-{
-  struct iwreq iw;
-  size_t iface_len;
-  int fd, r;
-
-  if (!iface || (iface_len = strlen(iface)) > IFNAMSIZ)
-    return ErrInvalidArg;
-
-  strncpy(iw.ifr_ifrn.ifrn_name, iface, iface_len);
-
-  fd = socket(PF_PACKET, SOCK_RAW, htons(ETH_P_ALL));
-  if (fd < 0)
-    return strerror(errno);
-
-  iw.u.freq = (struct iw_freq){
-      // Note: the 'freq' field is overloaded with frequency + channel
-      // -> low numbers N<=1000 are encoded as e(xponent)=0 and m(antissa)=N
-      .m = channel,
-      .e = 0};
-  r = ioctl(fd, SIOCSIWFREQ, &iw);
-  close(fd);
-  if (r == -1)
-    return strerror(errno);
-  return NULL;
-}
-*/
-import "C"
-
 import (
 	"context"
-	"errors"
 	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
-	"unsafe"
 )
 
-// set the channel (frequency) for the interface <ifi> to <ch>
-func SetChannel(ifi string, ch int) error {
-	cifi := C.CString(ifi)
-	defer C.free(unsafe.Pointer(cifi))
-	cp := C.set_channel(cifi, C.int(ch))
-	if cp != nil {
-		return errors.New(C.GoString(cp))
+// Walks through a HopPlan, can be paused or pinned to a single channel while
+// running.
+type Hopper struct {
+	// failed channel switches, first for 64 bit alignment on 32 bit arm
+	errors   uint64
+	ifname   string
+	setter   ChannelSetter
+	mtx      sync.Mutex
+	strategy HopStrategy
+	paused   bool
+	pinned   *ChannelSpec
+	// bumped by every change, Run() rechecks before going idle
+	gen uint64
+	// Run() waits for a change, not for the dwell time
+	idle bool
+	// ChannelSpec we are tuned to, the zero value if unknown
+	current atomic.Value
+	// the last switches, oldest first, for TunedAt()
+	histMtx sync.Mutex
+	history []tuning
+	// state changed, reconsider what to do. Only sent when it can't wait for
+	// the end of the dwell time, cutting it short would skew the stats
+	wake chan struct{}
+}
+
+// nil <plan> and <setter> are replaced by the defaults, see DefaultHopPlan
+// and DefaultChannelSetter. Hops in plan order, unless SetStrategy() is used.
+func NewHopper(ifname string, plan *HopPlan, setter ChannelSetter) *Hopper {
+	if plan == nil {
+		plan = DefaultHopPlan()
+	}
+	return &Hopper{
+		ifname:   ifname,
+		setter:   setter,
+		strategy: NewRoundRobin(plan),
+		wake:     make(chan struct{}, 1),
 	}
-	return nil
 }
 
-// go through channels 1 to 13 until <-done
-func HopChannels(ctx context.Context, ifname string) {
-	var (
-		channel int
-		tick    *time.Ticker
-		err     error
-	)
-	tick = time.NewTicker(time.Second)
-	defer tick.Stop()
+// switches to look back on, workers lag behind the capture by much less
+const tuneHistoryLen = 128
+
+// tuned to spec since
+type tuning struct {
+	since time.Time
+	spec  ChannelSpec
+}
+
+func (h *Hopper) notify() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// apply a change of state, waking Run() if it is idle or the change has to
+// take effect <now>
+func (h *Hopper) update(now bool, change func()) {
+	h.mtx.Lock()
+	change()
+	h.gen++
+	wake := now || h.idle
+	h.mtx.Unlock()
+	if wake {
+		h.notify()
+	}
+}
+
+// tune to <spec> right away and stay there until Unpin()
+func (h *Hopper) Pin(spec ChannelSpec) {
+	h.update(true, func() { h.pinned = &spec })
+}
+
+// continue with the plan after Pin()
+func (h *Hopper) Unpin() {
+	h.update(false, func() { h.pinned = nil })
+}
+
+// stay on the current channel until Resume(), from the end of the current
+// step
+func (h *Hopper) Pause() {
+	h.update(false, func() { h.paused = true })
+}
+
+func (h *Hopper) Resume() {
+	h.update(false, func() { h.paused = false })
+}
+
+// replace the plan, starts with its first step after the current one.
+// DefaultHopPlan() if nil
+func (h *Hopper) SetPlan(plan *HopPlan) {
+	h.Strategy().Reset(plan)
+	h.update(false, func() {})
+}
+
+// replace the strategy, takes effect after the current step
+func (h *Hopper) SetStrategy(strategy HopStrategy) {
+	h.update(false, func() { h.strategy = strategy })
+}
+
+func (h *Hopper) Strategy() HopStrategy {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.strategy
+}
+
+// an interesting frame was received on <freq> MHz
+func (h *Hopper) Observe(freq int) {
+	h.Strategy().Observe(freq)
+}
+
+func (h *Hopper) tune(spec ChannelSpec) {
+	if h.setter == nil {
+		h.setter = DefaultChannelSetter()
+	}
+	log.Printf("channel = %v", spec)
+	// frames captured while switching were on either channel
+	h.record(ChannelSpec{})
+	err := h.setter.SetChannel(h.ifname, spec)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		atomic.AddUint64(&h.errors, 1)
+		spec = ChannelSpec{}
+	}
+	h.record(spec)
+	h.current.Store(spec)
+}
+
+func (h *Hopper) record(spec ChannelSpec) {
+	h.histMtx.Lock()
+	defer h.histMtx.Unlock()
+	if len(h.history) >= tuneHistoryLen {
+		h.history = append(h.history[:0], h.history[1:]...)
+	}
+	h.history = append(h.history, tuning{since: time.Now(), spec: spec})
+}
+
+// the channel we were tuned to at <t>, e.g. the capture time of a frame. ok
+// is false if unknown (switching, failed or longer ago than the history
+// goes back)
+func (h *Hopper) TunedAt(t time.Time) (spec ChannelSpec, ok bool) {
+	h.histMtx.Lock()
+	defer h.histMtx.Unlock()
+	// the first switch after t
+	i := sort.Search(len(h.history), func(i int) bool {
+		return h.history[i].since.After(t)
+	})
+	if i == 0 {
+		return ChannelSpec{}, false
+	}
+	spec = h.history[i-1].spec
+	return spec, spec.Channel != 0
+}
+
+// channel switches that failed, since the start
+func (h *Hopper) Errors() uint64 {
+	return atomic.LoadUint64(&h.errors)
+}
+
+// the channel we are tuned to, ok is false if unknown (not started yet or the
+// last switch failed)
+func (h *Hopper) Current() (spec ChannelSpec, ok bool) {
+	spec, ok = h.current.Load().(ChannelSpec)
+	return spec, ok && spec.Channel != 0
+}
+
+// hop until <-ctx.Done()
+func (h *Hopper) Run(ctx context.Context) {
 	for {
-		select {
-		case <-tick.C:
-			log.Printf("channel = %d", channel+1)
-			err = SetChannel(ifname, channel+1)
-			if err != nil {
-				log.Printf("Error: %v", err)
+		var (
+			timer *time.Timer
+			dwell <-chan time.Time // nil: wait for a state change
+		)
+		h.mtx.Lock()
+		pinned, paused, strategy, gen := h.pinned, h.paused, h.strategy, h.gen
+		h.mtx.Unlock()
+
+		if pinned != nil {
+			h.tune(*pinned)
+		} else if !paused {
+			if step, ok := strategy.Next(); ok {
+				h.tune(step.ChannelSpec)
+				if step.Dwell == 0 {
+					step.Dwell = DwellDefault
+				}
+				timer = time.NewTimer(step.Dwell)
+				dwell = timer.C
 			}
-			channel = (channel + 1) % 13
+		}
+
+		if dwell == nil {
+			// changes meanwhile didn't wake us
+			h.mtx.Lock()
+			changed := gen != h.gen
+			h.idle = !changed
+			h.mtx.Unlock()
+			if changed {
+				continue
+			}
+		}
+
+		select {
+		case <-dwell:
+		case <-h.wake:
 		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		h.mtx.Lock()
+		h.idle = false
+		h.mtx.Unlock()
+		if ctx.Err() != nil {
 			return
 		}
 	}
 }
+
+// go through channels 1 to 13 until <-done
+func HopChannels(ctx context.Context, ifname string) {
+	NewHopper(ifname, nil, nil).Run(ctx)
+}
@@ -0,0 +1,129 @@
+package wifi
+
+import (
+	"context"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Decodes only the layers we look at, into preallocated ones. The
+// InterestingLayers returned point into the decoder and the packet data, so
+// they are only valid until the next decode(). Not safe for concurrent use.
+type decoder struct {
+	rt      layers.RadioTap
+	dot11   layers.Dot11
+	probe   layers.Dot11MgmtProbeReq
+	ies     []layers.Dot11InformationElement
+	ils     InterestingLayers
+	parser  *gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+}
+
+func newDecoder() *decoder {
+	d := &decoder{decoded: make([]gopacket.LayerType, 0, 4)}
+	d.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeRadioTap, &d.rt, &d.dot11, &d.probe)
+	// everything after the 802.11 header we don't care about
+	d.parser.IgnoreUnsupported = true
+	return d
+}
+
+// like NewInterestingLayers, nil if there is no radiotap + 802.11
+func (d *decoder) decode(data []byte, ci gopacket.CaptureInfo) *InterestingLayers {
+	// the layers only set what is in the packet, don't keep the last one's
+	d.rt, d.dot11, d.probe = layers.RadioTap{}, layers.Dot11{}, layers.Dot11MgmtProbeReq{}
+	d.parser.DecodeLayers(data, &d.decoded)
+	var rt, dot11, probe bool
+	for _, typ := range d.decoded {
+		switch typ {
+		case layers.LayerTypeRadioTap:
+			rt = true
+		case layers.LayerTypeDot11:
+			dot11 = true
+		case layers.LayerTypeDot11MgmtProbeReq:
+			probe = true
+		}
+	}
+	if !rt || !dot11 {
+		return nil
+	}
+	d.ils = InterestingLayers{
+		RT:    &d.rt,
+		Dot11: &d.dot11,
+		Stamp: ci.Timestamp,
+		data:  data,
+		ci:    ci,
+	}
+	if probe && d.ils.isProbeRequest() {
+		d.ies = decodeIEsInto(d.ies[:0], d.probe.LayerContents())
+		d.ils.IEs = d.ils.IEs[:0]
+		for i := range d.ies {
+			d.ils.IEs = append(d.ils.IEs, &d.ies[i])
+		}
+	}
+	return &d.ils
+}
+
+// Read from <src> until it is exhausted or ctx is Done(). Packet data is
+// only valid during <emit>, it is reused if <src> can read without copying.
+func (w *Wifi) read(ctx context.Context, src PacketSource, emit func(data []byte, ci gopacket.CaptureInfo)) {
+	next := src.ReadPacketData
+	if zc, ok := src.(gopacket.ZeroCopyPacketDataSource); ok {
+		next = zc.ZeroCopyReadPacketData
+	}
+	for ctx.Err() == nil {
+		data, ci, err := next()
+		if err != nil {
+			if !retryRead(err) {
+				if err != io.EOF {
+					log.Printf("Error: reading packets: %v", err)
+				}
+				return
+			}
+			continue
+		}
+		w.stats.inc("total")
+		// here, so files are in capture order and keep what workers drop
+		if w.tee != nil && !w.tee.Filtered {
+			w.teeData(ci, data)
+		}
+		emit(data, ci)
+	}
+}
+
+// what gopacket.PacketSource does: retry temporary errors, give up on
+// closed sources and sleep on anything else
+func retryRead(err error) bool {
+	if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+		return true
+	}
+	if err == syscall.EAGAIN {
+		return true
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF ||
+		err == io.ErrNoProgress || err == io.ErrClosedPipe || err == io.ErrShortBuffer ||
+		err == syscall.EBADF ||
+		strings.Contains(err.Error(), "use of closed file") {
+		return false
+	}
+	time.Sleep(time.Millisecond * 5)
+	return true
+}
+
+// decode and hand on a single packet, <data> is not used after returning
+func (w *Wifi) process(ctx context.Context, d *decoder, data []byte, ci gopacket.CaptureInfo) {
+	ils := d.decode(data, ci)
+	if ils == nil {
+		w.stats.inc("filtered")
+		return
+	}
+	w.stats.frame(ils.Dot11.Type, int(ils.RT.ChannelFrequency))
+	ils.Tuned, _ = w.hopper.TunedAt(ils.Stamp)
+	ils.Fix = w.locate(ils.Stamp)
+	w.handle(ctx, ils)
+}
@@ -0,0 +1,156 @@
+package wifi
+
+import (
+	"context"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"io"
+	"testing"
+	"time"
+)
+
+// a bit of everything Listen sees
+func mixedFrames(t testing.TB) [][]byte {
+	sta, ap := mac(t, "00:1b:63:00:00:01"), mac(t, "00:1b:63:00:00:02")
+	bcast := layers.EthernetBroadcast
+	return [][]byte{
+		probeRequest(t, "00:1b:63:00:00:01", 2412, -60,
+			ie(layers.Dot11InformationElementIDSSID),
+			ie(layers.Dot11InformationElementIDRates, 0x82, 0x84, 0x8b, 0x96),
+			ie(layers.Dot11InformationElementIDVendor, 0x00, 0x50, 0xf2, 0x08, 0x00, 0x10)).Data(),
+		probeRequest(t, "02:00:00:00:00:01", 5180, -80).Data(),
+		frame(t, &layers.Dot11{Type: layers.Dot11TypeMgmtBeacon, Address1: bcast, Address2: ap, Address3: ap}),
+		frame(t, &layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsToDS, Address1: ap, Address2: sta, Address3: ap}),
+		frame(t, &layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsFromDS, Address1: sta, Address2: ap, Address3: ap}),
+		frame(t, &layers.Dot11{Type: layers.Dot11TypeCtrlAck, Address1: sta}),
+		// radiotap without signal and frequency, nothing may be left over
+		append([]byte{0, 0, 8, 0, 0, 0, 0, 0}, frame(t, &layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsToDS, Address1: ap, Address2: sta, Address3: ap})[13:]...),
+		{0, 0, 8, 0, 0, 0, 0, 0},
+		{0, 0},
+	}
+}
+
+// testdata, or mixedFrames if it's not there
+func benchFrames(b *testing.B) [][]byte {
+	hnd, err := pcap.OpenOffline("testdata/random-wifi.cap")
+	if err != nil {
+		b.Logf("using generated frames: %v", err)
+		return mixedFrames(b)
+	}
+	defer hnd.Close()
+	var frames [][]byte
+	for {
+		data, _, err := hnd.ReadPacketData()
+		if err != nil {
+			break
+		}
+		frames = append(frames, data)
+	}
+	return frames
+}
+
+// replays frames in memory, n times
+type replaySource struct {
+	frames [][]byte
+	n, i   int
+}
+
+func (s *replaySource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if s.i >= s.n*len(s.frames) {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	data := s.frames[s.i%len(s.frames)]
+	s.i++
+	return data, gopacket.CaptureInfo{Timestamp: time.Unix(0, int64(s.i)), CaptureLength: len(data), Length: len(data)}, nil
+}
+
+func (s *replaySource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := s.ZeroCopyReadPacketData()
+	return append([]byte(nil), data...), ci, err
+}
+
+func (s *replaySource) LinkType() layers.LinkType {
+	return layers.LinkTypeIEEE80211Radio
+}
+
+func TestDecoderMatchesNewInterestingLayers(t *testing.T) {
+	d := newDecoder()
+	frames := mixedFrames(t)
+	// twice, in order to see leftovers of earlier frames
+	for i := 0; i < 2*len(frames); i++ {
+		data := frames[i%len(frames)]
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(i), 0)}
+		p := gopacket.NewPacket(data, layers.LayerTypeRadioTap, gopacket.Default)
+		p.Metadata().CaptureInfo = ci
+		want, got := NewInterestingLayers(p), d.decode(data, ci)
+		if (want == nil) != (got == nil) {
+			t.Errorf("frame %d: eager=%v lazy=%v", i, want, got)
+			continue
+		}
+		if want == nil {
+			continue
+		}
+		if want.filter() != got.filter() {
+			t.Errorf("frame %d: filter eager=%v lazy=%v", i, want.filter(), got.filter())
+		}
+		wdev, waddr := want.ToDevice()
+		gdev, gaddr := got.ToDevice()
+		if waddr.String() != gaddr.String() || wdev.String() != gdev.String() {
+			t.Errorf("frame %d:\neager %v\nlazy  %v", i, wdev, gdev)
+		}
+	}
+}
+
+func TestListenWorkers(t *testing.T) {
+	src := &replaySource{frames: mixedFrames(t), n: 100}
+	w := NewWifi(WifiConfig{Source: src, Workers: 4, DisableLinker: true})
+	ndevs := 0
+	for range w.Start(context.Background()) {
+		ndevs++
+	}
+	// the probe requests and the data to/from the station
+	if ndevs != 5*src.n {
+		t.Errorf("%d devices, %v", ndevs, w.stats)
+	}
+	if w.stats.get("total") != len(src.frames)*src.n {
+		t.Errorf("not all packets read: %v", w.stats)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	frames := benchFrames(b)
+	d := newDecoder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data := frames[i%len(frames)]
+		if ils := d.decode(data, gopacket.CaptureInfo{}); ils != nil && ils.isProbeRequest() {
+			NewFingerprint(ils.IEs)
+		}
+	}
+}
+
+// what Listen did before the decoder
+func BenchmarkNewInterestingLayers(b *testing.B) {
+	frames := benchFrames(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := gopacket.NewPacket(frames[i%len(frames)], layers.LayerTypeRadioTap, gopacket.Default)
+		if ils := NewInterestingLayers(p); ils != nil && ils.isProbeRequest() {
+			NewFingerprint(ils.IEs)
+		}
+	}
+}
+
+// packets per op, through the whole pipeline
+func BenchmarkListen(b *testing.B) {
+	frames := benchFrames(b)
+	src := &replaySource{frames: frames, n: (b.N + len(frames) - 1) / len(frames)}
+	w := NewWifi(WifiConfig{Source: src})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range w.Start(context.Background()) {
+	}
+}
@@ -3,4 +3,4 @@ In agency terms "Signal Intelligence" (#sigint), but I don't care.
 */
 package wifi
 
-//go:generate go run ./tools/generate_protobuf.go -i ./proto ./proto/device.proto ./proto/collector.proto ./proto/human.proto ./proto/datapoint.proto
+//go:generate go run ./tools/generate_protobuf.go -i ./proto ./proto/device.proto ./proto/collector.proto ./proto/human.proto ./proto/datapoint.proto ./proto/fingerprint.proto ./proto/query.proto
@@ -0,0 +1,100 @@
+// Write sightings in formats GIS tools understand.
+package export
+
+import (
+	"context"
+	"fmt"
+	"github.com/tinygoprogs/sigint/wifi"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// what reads sightings, e.g. a local.Store
+type Querier interface {
+	Query(ctx context.Context, q *wifi.SightingQuery) (*wifi.Sightings, error)
+}
+
+// One format. Sightings are written in the order they come, Close() must be
+// called to finish the document, it does not close the underlying writer.
+type Writer interface {
+	Write(s *wifi.Sighting) error
+	Close() error
+}
+
+var Formats = []string{"geojson", "kml", "csv"}
+
+// <format> is one of Formats
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case "geojson":
+		return NewGeoJSON(w), nil
+	case "kml":
+		return NewKML(w), nil
+	case "csv":
+		return NewCSV(w), nil
+	}
+	return nil, CheckFormat(format)
+}
+
+// nil if <format> is one of Formats
+func CheckFormat(format string) error {
+	for _, f := range Formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format '%s', have %v", format, Formats)
+}
+
+// the format of a file name, "" if unknown
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".geojson", ".json":
+		return "geojson"
+	case ".kml":
+		return "kml"
+	case ".csv":
+		return "csv"
+	}
+	return ""
+}
+
+// Write everything matching <q> to <w>, page by page, and Close() it.
+// Returns the number of sightings written.
+func Export(ctx context.Context, src Querier, q wifi.SightingQuery, w Writer) (n int, err error) {
+	for {
+		var res *wifi.Sightings
+		res, err = src.Query(ctx, &q)
+		if err != nil {
+			return
+		}
+		for _, s := range res.Sightings {
+			if err = w.Write(s); err != nil {
+				return
+			}
+			n++
+		}
+		if res.NextPageToken == "" {
+			break
+		}
+		q.PageToken = res.NextPageToken
+	}
+	return n, w.Close()
+}
+
+// datapoints without a fix have 0, 0, see wireless.go
+func located(s *wifi.Sighting) bool {
+	loc := s.GetDataPoint().GetLocation()
+	return loc.GetLon() != 0 || loc.GetLat() != 0
+}
+
+func stamp(s *wifi.Sighting) time.Time {
+	return time.Unix(0, int64(s.GetDataPoint().GetTimeStamp())).UTC()
+}
+
+// in dBm
+func signal(s *wifi.Sighting) int8 {
+	return int8(s.GetDataPoint().GetSignal())
+}
@@ -0,0 +1,138 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/tinygoprogs/sigint/wifi"
+	"testing"
+)
+
+// serves <all> in pages of <limit>
+type fakeQuerier struct {
+	all   []*wifi.Sighting
+	limit int
+	calls int
+}
+
+func (f *fakeQuerier) Query(ctx context.Context, q *wifi.SightingQuery) (*wifi.Sightings, error) {
+	f.calls++
+	var start int
+	if q.PageToken != "" {
+		fmt.Sscan(q.PageToken, &start)
+	}
+	end := start + f.limit
+	res := &wifi.Sightings{}
+	if end < len(f.all) {
+		res.NextPageToken = fmt.Sprint(end)
+	} else {
+		end = len(f.all)
+	}
+	res.Sightings = f.all[start:end]
+	return res, nil
+}
+
+func sighting(mac string, stamp uint64, signal int8, lon, lat float32) *wifi.Sighting {
+	return &wifi.Sighting{MAC: mac, DataPoint: &wifi.DataPoint{
+		TimeStamp: stamp, Signal: uint32(signal), Frequency: 2412,
+		Location: &wifi.Coordinates{Lon: lon, Lat: lat, Acc: 5},
+	}}
+}
+
+var sightings = []*wifi.Sighting{
+	sighting("02:00:00:00:00:02", 1e18, -40, 11.6, 48.1),
+	sighting("02:00:00:00:00:01", 1e18+1, -50, 11.5, 48.2),
+	// no location
+	sighting("02:00:00:00:00:01", 1e18+2, -60, 0, 0),
+	sighting("02:00:00:00:00:02", 1e18+3, -70, 11.7, 48.3),
+}
+
+func exportAll(t *testing.T, format string) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &fakeQuerier{all: sightings, limit: 3}
+	n, err := Export(context.Background(), src, wifi.SightingQuery{}, w)
+	if err != nil || n != len(sightings) || src.calls != 2 {
+		t.Fatalf("%d sightings in %d queries: %v", n, src.calls, err)
+	}
+	return buf.Bytes()
+}
+
+func TestGeoJSON(t *testing.T) {
+	var doc struct {
+		Type     string
+		Features []geoFeature
+	}
+	data := exportAll(t, "geojson")
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+	if doc.Type != "FeatureCollection" || len(doc.Features) != 3 {
+		t.Fatalf("got %s", data)
+	}
+	f := doc.Features[0]
+	if f.Geometry.Type != "Point" || f.Geometry.Coordinates[0] != 11.6 || f.Geometry.Coordinates[1] != 48.1 ||
+		f.Properties.Signal != -40 || f.Properties.Time != "2001-09-09T01:46:40Z" {
+		t.Errorf("got %+v", f)
+	}
+
+	var buf bytes.Buffer
+	g := NewGeoJSON(&buf)
+	g.Close()
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil || len(doc.Features) != 0 {
+		t.Errorf("empty collection %s: %v", buf.Bytes(), err)
+	}
+}
+
+func TestKML(t *testing.T) {
+	var doc kmlDoc
+	data := exportAll(t, "kml")
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+	if len(doc.Folders) != 2 {
+		t.Fatalf("got %s", data)
+	}
+	one, two := doc.Folders[0], doc.Folders[1]
+	if one.Name != "02:00:00:00:00:01" || len(one.Placemarks) != 1 || two.Name != "02:00:00:00:00:02" || len(two.Placemarks) != 2 {
+		t.Fatalf("got %s", data)
+	}
+	if p := two.Placemarks[1]; p.Coordinates != "11.7,48.3,0" || p.When != "2001-09-09T01:46:40.000000003Z" || p.Name != "-70 dBm" {
+		t.Errorf("got %+v", p)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	rows, err := csv.NewReader(bytes.NewReader(exportAll(t, "csv"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 || rows[0][0] != "mac" {
+		t.Fatalf("got %v", rows)
+	}
+	if rows[2][2] != "-50" || rows[2][4] != "11.5" || rows[2][5] != "48.2" {
+		t.Errorf("got %v", rows[2])
+	}
+	if rows[3][4] != "" || rows[3][5] != "" {
+		t.Errorf("location for %v", rows[3])
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	for path, format := range map[string]string{
+		"night.geojson": "geojson", "night.KML": "kml", "out/night.csv": "csv", "night.txt": "",
+	} {
+		if got := FormatOf(path); got != format {
+			t.Errorf("%s: got '%s', want '%s'", path, got, format)
+		}
+	}
+	if _, err := NewWriter("shp", nil); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
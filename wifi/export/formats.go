@@ -0,0 +1,192 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/tinygoprogs/sigint/wifi"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A FeatureCollection of Points, streamed. Sightings without a location are
+// skipped.
+type GeoJSON struct {
+	w     io.Writer
+	count int
+	err   error
+}
+
+func NewGeoJSON(w io.Writer) *GeoJSON {
+	return &GeoJSON{w: w}
+}
+
+type geoFeature struct {
+	Type     string `json:"type"`
+	Geometry struct {
+		Type        string    `json:"type"`
+		Coordinates []float64 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties struct {
+		MAC       string  `json:"mac"`
+		Time      string  `json:"time"`
+		Signal    int8    `json:"signal"`
+		Frequency uint32  `json:"frequency"`
+		Accuracy  float64 `json:"accuracy,omitempty"`
+	} `json:"properties"`
+}
+
+func (g *GeoJSON) Write(s *wifi.Sighting) error {
+	if g.err != nil || !located(s) {
+		return g.err
+	}
+	loc := s.DataPoint.Location
+	f := geoFeature{Type: "Feature"}
+	f.Geometry.Type = "Point"
+	f.Geometry.Coordinates = []float64{f32(loc.Lon), f32(loc.Lat)}
+	if loc.Alt != 0 {
+		f.Geometry.Coordinates = append(f.Geometry.Coordinates, loc.Alt)
+	}
+	f.Properties.MAC = s.MAC
+	f.Properties.Time = stamp(s).Format(time.RFC3339Nano)
+	f.Properties.Signal = signal(s)
+	f.Properties.Frequency = s.DataPoint.Frequency
+	f.Properties.Accuracy = loc.Acc
+	data, err := json.Marshal(&f)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if g.count == 0 {
+		sep = `{"type":"FeatureCollection","features":[` + "\n"
+	}
+	g.count++
+	_, g.err = fmt.Fprintf(g.w, "%s%s", sep, data)
+	return g.err
+}
+
+func (g *GeoJSON) Close() error {
+	if g.err != nil {
+		return g.err
+	}
+	if g.count == 0 {
+		_, g.err = io.WriteString(g.w, `{"type":"FeatureCollection","features":[]}`+"\n")
+	} else {
+		_, g.err = io.WriteString(g.w, "\n]}\n")
+	}
+	return g.err
+}
+
+// A Folder of Placemarks per device, with timestamps so Google Earth can
+// animate them. Kept in memory until Close(), to group them. Sightings
+// without a location are skipped.
+type KML struct {
+	w       io.Writer
+	devices map[string][]kmlPlacemark
+}
+
+func NewKML(w io.Writer) *KML {
+	return &KML{w: w, devices: map[string][]kmlPlacemark{}}
+}
+
+type kmlPlacemark struct {
+	Name        string `xml:"name"`
+	When        string `xml:"TimeStamp>when"`
+	Description string `xml:"description"`
+	Coordinates string `xml:"Point>coordinates"`
+}
+
+type kmlFolder struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlDoc struct {
+	XMLName xml.Name    `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Name    string      `xml:"Document>name"`
+	Folders []kmlFolder `xml:"Document>Folder"`
+}
+
+func (k *KML) Write(s *wifi.Sighting) error {
+	if !located(s) {
+		return nil
+	}
+	loc := s.DataPoint.Location
+	k.devices[s.MAC] = append(k.devices[s.MAC], kmlPlacemark{
+		Name: fmt.Sprintf("%d dBm", signal(s)),
+		When: stamp(s).Format(time.RFC3339Nano),
+		Description: fmt.Sprintf("%s, %d MHz, accuracy %.0fm",
+			s.MAC, s.DataPoint.Frequency, loc.Acc),
+		Coordinates: fmt.Sprintf("%s,%s,%s", ftoa(f32(loc.Lon)), ftoa(f32(loc.Lat)), ftoa(loc.Alt)),
+	})
+	return nil
+}
+
+func (k *KML) Close() error {
+	doc := kmlDoc{Name: "sigint wifi sightings"}
+	for mac, placemarks := range k.devices {
+		doc.Folders = append(doc.Folders, kmlFolder{Name: mac, Placemarks: placemarks})
+	}
+	sort.Slice(doc.Folders, func(i, j int) bool { return doc.Folders[i].Name < doc.Folders[j].Name })
+	if _, err := io.WriteString(k.w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(k.w)
+	enc.Indent("", " ")
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(k.w, "\n")
+	return err
+}
+
+// One row per sighting, including those without a location.
+type CSV struct {
+	w      *csv.Writer
+	header bool
+}
+
+func NewCSV(w io.Writer) *CSV {
+	return &CSV{w: csv.NewWriter(w)}
+}
+
+var csvHeader = []string{"mac", "time", "signal", "frequency", "longitude", "latitude", "altitude", "accuracy"}
+
+func (c *CSV) Write(s *wifi.Sighting) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	dp := s.DataPoint
+	row := []string{s.MAC, stamp(s).Format(time.RFC3339Nano),
+		strconv.Itoa(int(signal(s))), strconv.FormatUint(uint64(dp.Frequency), 10), "", "", "", ""}
+	if located(s) {
+		loc := dp.Location
+		row[4], row[5], row[6], row[7] = ftoa(f32(loc.Lon)), ftoa(f32(loc.Lat)), ftoa(loc.Alt), ftoa(loc.Acc)
+	}
+	return c.w.Write(row)
+}
+
+func (c *CSV) Close() error {
+	if !c.header {
+		c.header = true
+		c.w.Write(csvHeader)
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// coordinates are float32, without this 11.6 ends up as 11.600000381469727
+func f32(f float32) float64 {
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+	return v
+}
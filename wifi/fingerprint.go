@@ -0,0 +1,111 @@
+package wifi
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/google/gopacket/layers"
+)
+
+// not (yet) named by gopacket
+const (
+	ieIDExtension   layers.Dot11InformationElementID = 255
+	ieExtIDHECapabs                                  = 35
+)
+
+// decode the information elements of a probe request, wildcard SSIDs (empty)
+// are skipped
+func NewFingerprint(ies []*layers.Dot11InformationElement) *Fingerprint {
+	fp := &Fingerprint{}
+	for _, ie := range ies {
+		fp.IEIDs = append(fp.IEIDs, byte(ie.ID))
+		switch ie.ID {
+		case layers.Dot11InformationElementIDSSID:
+			if len(ie.Info) > 0 {
+				fp.SSIDs = append(fp.SSIDs, string(ie.Info))
+			}
+		case layers.Dot11InformationElementIDRates, layers.Dot11InformationElementIDESRates:
+			fp.Rates = append(fp.Rates, ie.Info...)
+		case layers.Dot11InformationElementIDHTCapabilities:
+			fp.HTCapabilities = copyBytes(ie.Info)
+		case layers.Dot11InformationElementIDVHTCapabilities:
+			fp.VHTCapabilities = copyBytes(ie.Info)
+		case layers.Dot11InformationElementIDExtCapability:
+			fp.ExtCapabilities = copyBytes(ie.Info)
+		case layers.Dot11InformationElementIDVendor:
+			// gopacket puts OUI + vendor specific type into OUI
+			if len(ie.OUI) == 4 {
+				fp.VendorOUIs = append(fp.VendorOUIs, binary.BigEndian.Uint32(ie.OUI))
+			}
+		case ieIDExtension:
+			if len(ie.Info) > 0 && ie.Info[0] == ieExtIDHECapabs {
+				fp.HECapabilities = copyBytes(ie.Info[1:])
+			}
+		}
+	}
+	return fp
+}
+
+// hex encoded hash over everything but the SSIDs, two devices of the same
+// model + firmware are likely to share it
+func (fp *Fingerprint) Digest() string {
+	h := sha1.New()
+	for _, field := range [][]byte{fp.Rates, fp.HTCapabilities,
+		fp.VHTCapabilities, fp.HECapabilities, fp.ExtCapabilities, fp.IEIDs} {
+		binary.Write(h, binary.BigEndian, uint16(len(field)))
+		h.Write(field)
+	}
+	binary.Write(h, binary.BigEndian, fp.VendorOUIs)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// parse a management frame body consisting only of information elements,
+// stops at the first malformed one
+func decodeIEs(data []byte) (ies []*layers.Dot11InformationElement) {
+	for len(data) > 0 {
+		ie := &layers.Dot11InformationElement{}
+		if !decodeIE(ie, data) {
+			break
+		}
+		ies = append(ies, ie)
+		data = ie.LayerPayload()
+	}
+	return
+}
+
+// like decodeIEs, appending to <ies> to reuse them
+func decodeIEsInto(ies []layers.Dot11InformationElement, data []byte) []layers.Dot11InformationElement {
+	for len(data) > 0 {
+		ies = append(ies, layers.Dot11InformationElement{})
+		ie := &ies[len(ies)-1]
+		if !decodeIE(ie, data) {
+			return ies[:len(ies)-1]
+		}
+		data = ie.LayerPayload()
+	}
+	return ies
+}
+
+// What Dot11InformationElement.DecodeFromBytes does, except that it wants 4
+// bytes of value even for elements that are shorter, e.g. an empty SSID at
+// the end of the frame.
+func decodeIE(ie *layers.Dot11InformationElement, data []byte) bool {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return false
+	}
+	end := 2 + int(data[1])
+	ie.ID = layers.Dot11InformationElementID(data[0])
+	ie.Length = data[1]
+	ie.OUI, ie.Info = nil, data[2:end]
+	if ie.ID == layers.Dot11InformationElementIDVendor && ie.Length >= 4 {
+		// OUI + vendor specific type
+		ie.OUI, ie.Info = data[2:6], data[6:end]
+	}
+	ie.BaseLayer = layers.BaseLayer{Contents: data[:end], Payload: data[end:]}
+	return true
+}
+
+// IE data points into the packet buffer, which may be reused
+func copyBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
@@ -0,0 +1,133 @@
+package wifi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+	"testing"
+)
+
+// radiotap header with channel + antenna signal, followed by a probe request
+// from <sa> carrying <ies>
+func probeRequest(t testing.TB, sa string, freq uint16, signal int8, ies ...layers.Dot11InformationElement) gopacket.Packet {
+	addr, err := net.ParseMAC(sa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := []byte{0, 0, 13, 0, 0x28, 0, 0, 0, 0, 0, 0xa0, 0, byte(signal)}
+	binary.LittleEndian.PutUint16(rt[8:], freq)
+	buf := gopacket.NewSerializeBuffer()
+	sls := []gopacket.SerializableLayer{&layers.Dot11{
+		Type:     layers.Dot11TypeMgmtProbeReq,
+		Address1: layers.EthernetBroadcast,
+		Address2: addr,
+		Address3: layers.EthernetBroadcast,
+	}}
+	for i := range ies {
+		sls = append(sls, &ies[i])
+	}
+	err = gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, sls...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(append(rt, buf.Bytes()...), layers.LayerTypeRadioTap, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatalf("broken test packet: %v", p.ErrorLayer().Error())
+	}
+	return p
+}
+
+func ie(id layers.Dot11InformationElementID, info ...byte) layers.Dot11InformationElement {
+	return layers.Dot11InformationElement{ID: id, Length: uint8(len(info)), Info: info}
+}
+
+func TestProbeRequestToDevice(t *testing.T) {
+	p := probeRequest(t, "02:11:22:33:44:55", 2412, -42,
+		ie(layers.Dot11InformationElementIDSSID),
+		ie(layers.Dot11InformationElementIDSSID, []byte("home")...),
+		ie(layers.Dot11InformationElementIDRates, 0x82, 0x84, 0x8b, 0x96),
+		ie(layers.Dot11InformationElementIDESRates, 0x0c, 0x12),
+		ie(layers.Dot11InformationElementIDHTCapabilities, 0x2d, 0x01),
+		ie(ieIDExtension, ieExtIDHECapabs, 0x01, 0x02),
+		layers.Dot11InformationElement{
+			ID: layers.Dot11InformationElementIDVendor, Length: 5,
+			OUI: []byte{0x00, 0x50, 0xf2, 0x08}, Info: []byte{0x00},
+		},
+	)
+	ils := NewInterestingLayers(p)
+	if ils == nil || ils.filter() {
+		t.Fatalf("probe request not interesting: %v", p)
+	}
+	dev, addr := ils.ToDevice()
+	if addr.String() != "02:11:22:33:44:55" || dev.MAC != addr.String() {
+		t.Errorf("wrong sender: %v, %v", addr, dev.MAC)
+	}
+	if !dev.Randomized {
+		t.Error("locally administered address not flagged")
+	}
+	fp := dev.Fingerprint
+	if fp == nil {
+		t.Fatal("no fingerprint")
+	}
+	if len(fp.SSIDs) != 1 || fp.SSIDs[0] != "home" {
+		t.Errorf("SSIDs = %q", fp.SSIDs)
+	}
+	if !bytes.Equal(fp.Rates, []byte{0x82, 0x84, 0x8b, 0x96, 0x0c, 0x12}) {
+		t.Errorf("Rates = %x", fp.Rates)
+	}
+	if !bytes.Equal(fp.HTCapabilities, []byte{0x2d, 0x01}) {
+		t.Errorf("HTCapabilities = %x", fp.HTCapabilities)
+	}
+	if !bytes.Equal(fp.HECapabilities, []byte{0x01, 0x02}) {
+		t.Errorf("HECapabilities = %x", fp.HECapabilities)
+	}
+	if len(fp.VendorOUIs) != 1 || fp.VendorOUIs[0] != 0x0050f208 {
+		t.Errorf("VendorOUIs = %x", fp.VendorOUIs)
+	}
+	if !bytes.Equal(fp.IEIDs, []byte{0, 0, 1, 50, 45, 255, 221}) {
+		t.Errorf("IEIDs = %v", fp.IEIDs)
+	}
+}
+
+func TestFingerprintDigestIgnoresSSIDs(t *testing.T) {
+	a := &Fingerprint{SSIDs: []string{"a"}, Rates: []byte{1, 2}, IEIDs: []byte{0, 1}}
+	b := &Fingerprint{SSIDs: []string{"b"}, Rates: []byte{1, 2}, IEIDs: []byte{0, 1}}
+	c := &Fingerprint{SSIDs: []string{"a"}, Rates: []byte{1}, IEIDs: []byte{2, 0, 1}}
+	if a.Digest() != b.Digest() {
+		t.Error("SSIDs changed the digest")
+	}
+	if a.Digest() == c.Digest() {
+		t.Error("different capabilities, same digest")
+	}
+}
+
+func TestDecodeIEs(t *testing.T) {
+	// SSID, rates, extended capabilities and a wildcard SSID, the last ones
+	// shorter than an OUI
+	body := []byte{0, 4, 'h', 'o', 'm', 'e', 1, 1, 0x82, 127, 1, 0x04, 0, 0,
+		// vendor, OUI + type + data
+		221, 5, 0x00, 0x50, 0xf2, 0x04, 0x10,
+		// truncated
+		1, 5, 0x82}
+	ies := decodeIEs(body)
+	into := decodeIEsInto(nil, body)
+	if len(ies) != 5 || len(into) != 5 {
+		t.Fatalf("got %v, %v", ies, into)
+	}
+	for i, id := range []layers.Dot11InformationElementID{0, 1, 127, 0, 221} {
+		if ies[i].ID != id || into[i].ID != id || !bytes.Equal(ies[i].Info, into[i].Info) {
+			t.Errorf("element %d: got %v, %v", i, ies[i], &into[i])
+		}
+	}
+	if string(ies[0].Info) != "home" || len(ies[3].Info) != 0 {
+		t.Errorf("SSIDs: %v, %v", ies[0], ies[3])
+	}
+	if !bytes.Equal(ies[4].OUI, []byte{0x00, 0x50, 0xf2, 0x04}) || !bytes.Equal(ies[4].Info, []byte{0x10}) {
+		t.Errorf("vendor: %v", ies[4])
+	}
+	if fp := NewFingerprint(ies); !bytes.Equal(fp.IEIDs, []byte{0, 1, 127, 0, 221}) || len(fp.VendorOUIs) != 1 {
+		t.Errorf("got %v", fp)
+	}
+}
@@ -0,0 +1,248 @@
+package wifi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DwellDefault = time.Second
+const RegdomainDefault = "00"
+
+type HopStep struct {
+	ChannelSpec
+	// how long to listen on this channel, DwellDefault if 0
+	Dwell time.Duration
+}
+
+func (s HopStep) String() string {
+	return fmt.Sprintf("%v:%v", s.ChannelSpec, s.Dwell)
+}
+
+// the channels to hop through, in order
+type HopPlan struct {
+	Steps []HopStep
+}
+
+// can be passed empty, sane defaults will be choosen
+type HopPlanConfig struct {
+	// Band2GHz if 0
+	Bands Band
+	Width ChannelWidth
+	Dwell time.Duration
+	// ISO 3166 alpha2 country code, RegdomainDefault if empty
+	Regdomain string
+}
+
+var (
+	channels2GHz = channelRange(1, 14, 1)
+	channels5GHz = append(append(channelRange(36, 64, 4), channelRange(100, 144, 4)...),
+		channelRange(149, 165, 4)...)
+	channels6GHz = channelRange(1, 233, 4)
+)
+
+// usable channels per band, a coarse summary of the wireless-regdb, "00" is
+// the world domain
+var Regdomains = map[string]map[Band][]int{
+	"00": {
+		Band2GHz: channelRange(1, 13, 1),
+		Band5GHz: channels5GHz,
+	},
+	"US": {
+		Band2GHz: channelRange(1, 11, 1),
+		Band5GHz: channels5GHz,
+		Band6GHz: channels6GHz,
+	},
+	"EU": {
+		Band2GHz: channelRange(1, 13, 1),
+		Band5GHz: append(channelRange(36, 64, 4), channelRange(100, 140, 4)...),
+		Band6GHz: channelRange(1, 93, 4),
+	},
+	"JP": {
+		Band2GHz: channels2GHz,
+		Band5GHz: append(channelRange(36, 64, 4), channelRange(100, 144, 4)...),
+		Band6GHz: channelRange(1, 93, 4),
+	},
+	"CN": {
+		Band2GHz: channelRange(1, 13, 1),
+		Band5GHz: append(channelRange(36, 64, 4), channelRange(149, 165, 4)...),
+	},
+}
+
+// countries sharing the rules of a domain above
+var RegdomainAliases = map[string]string{
+	"CA": "US",
+	"AT": "EU", "BE": "EU", "BG": "EU", "CH": "EU", "CY": "EU", "CZ": "EU",
+	"DE": "EU", "DK": "EU", "EE": "EU", "ES": "EU", "FI": "EU", "FR": "EU",
+	"GB": "EU", "GR": "EU", "HR": "EU", "HU": "EU", "IE": "EU", "IS": "EU",
+	"IT": "EU", "LI": "EU", "LT": "EU", "LU": "EU", "LV": "EU", "MT": "EU",
+	"NL": "EU", "NO": "EU", "PL": "EU", "PT": "EU", "RO": "EU", "SE": "EU",
+	"SI": "EU", "SK": "EU",
+}
+
+// comma separated list of "2.4", "5" and "6"
+func ParseBands(s string) (bands Band, err error) {
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSuffix(strings.TrimSpace(name), "GHz") {
+		case "2.4", "2":
+			bands |= Band2GHz
+		case "5":
+			bands |= Band5GHz
+		case "6":
+			bands |= Band6GHz
+		default:
+			return 0, fmt.Errorf("unknown band '%s'", name)
+		}
+	}
+	return
+}
+
+func channelRange(from, to, step int) (chs []int) {
+	for ch := from; ch <= to; ch += step {
+		chs = append(chs, ch)
+	}
+	return
+}
+
+func lookupRegdomain(regdomain string) (map[Band][]int, error) {
+	regdomain = strings.ToUpper(regdomain)
+	if alias, ok := RegdomainAliases[regdomain]; ok {
+		regdomain = alias
+	}
+	rules, ok := Regdomains[regdomain]
+	if !ok {
+		return nil, fmt.Errorf("unknown regulatory domain '%s'", regdomain)
+	}
+	return rules, nil
+}
+
+var narrower = map[ChannelWidth]ChannelWidth{Width160: Width80, Width80: Width40, Width40: Width20}
+
+// all channels of the selected bands, allowed in the regulatory domain. Steps
+// are narrowed to what each channel can bond to, up to Width
+func NewHopPlan(conf HopPlanConfig) (*HopPlan, error) {
+	if conf.Bands == 0 {
+		conf.Bands = Band2GHz
+	}
+	if conf.Dwell == 0 {
+		conf.Dwell = DwellDefault
+	}
+	if conf.Regdomain == "" {
+		conf.Regdomain = RegdomainDefault
+	}
+	rules, err := lookupRegdomain(conf.Regdomain)
+	if err != nil {
+		return nil, err
+	}
+	plan := &HopPlan{}
+	for _, band := range []Band{Band2GHz, Band5GHz, Band6GHz} {
+		if conf.Bands&band == 0 {
+			continue
+		}
+		for _, ch := range rules[band] {
+			spec := ChannelSpec{Band: band, Channel: ch, Width: conf.Width}
+			// as wide as the channel allows, e.g. at most 40MHz on 2.4GHz
+			for spec.Width.MHz() > 20 {
+				if _, err = spec.CenterFreq(); err == nil {
+					break
+				}
+				spec.Width = narrower[spec.Width]
+			}
+			if _, err = spec.CenterFreq(); err != nil {
+				return nil, err
+			}
+			plan.Steps = append(plan.Steps, HopStep{ChannelSpec: spec, Dwell: conf.Dwell})
+		}
+	}
+	return plan, nil
+}
+
+// what HopChannels always did: 2.4GHz channels 1 to 13, one second each
+func DefaultHopPlan() *HopPlan {
+	plan, _ := NewHopPlan(HopPlanConfig{})
+	return plan
+}
+
+// Parse a comma separated list of steps "<channel>[/<width>][:<dwell>]".
+//
+// Channels 1-14 are 2.4GHz, 32-196 are 5GHz, numbers >= 2400 are frequencies
+// in MHz (required for 6GHz). Width is in MHz, dwell a time.Duration, e.g.
+// "1,6,11:2s,36/80:500ms,5975".
+func ParseHopPlan(s string) (*HopPlan, error) {
+	plan := &HopPlan{}
+	for _, tok := range strings.Split(s, ",") {
+		var (
+			step HopStep
+			err  error
+		)
+		tok = strings.TrimSpace(tok)
+		if i := strings.Index(tok, ":"); i >= 0 {
+			step.Dwell, err = time.ParseDuration(tok[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("step '%s': %v", tok, err)
+			}
+			tok = tok[:i]
+		}
+		if i := strings.Index(tok, "/"); i >= 0 {
+			step.Width, err = parseWidth(tok[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("step '%s': %v", tok, err)
+			}
+			tok = tok[:i]
+		}
+		n, err := strconv.Atoi(tok)
+		if err != nil {
+			return nil, fmt.Errorf("step '%s': %v", tok, err)
+		}
+		switch {
+		case n >= 2400:
+			step.Band, step.Channel = FrequencyToChannel(n)
+		case n <= 14:
+			step.Band, step.Channel = Band2GHz, n
+		case n >= 32:
+			step.Band, step.Channel = Band5GHz, n
+		}
+		if step.Freq() == 0 {
+			return nil, fmt.Errorf("step '%s': invalid channel", tok)
+		}
+		if _, err = step.CenterFreq(); err != nil {
+			return nil, fmt.Errorf("step '%s': %v", tok, err)
+		}
+		if step.Dwell == 0 {
+			step.Dwell = DwellDefault
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan, nil
+}
+
+func parseWidth(s string) (ChannelWidth, error) {
+	for _, w := range []ChannelWidth{Width20, Width40, Width80, Width160} {
+		if strconv.Itoa(w.MHz()) == s {
+			return w, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported channel width '%s'", s)
+}
+
+// drop every step not allowed in <regdomain>
+func (p *HopPlan) Filter(regdomain string) (*HopPlan, error) {
+	rules, err := lookupRegdomain(regdomain)
+	if err != nil {
+		return nil, err
+	}
+	filtered := &HopPlan{}
+	for _, step := range p.Steps {
+		for _, ch := range rules[step.Band] {
+			if ch == step.Channel {
+				filtered.Steps = append(filtered.Steps, step)
+				break
+			}
+		}
+	}
+	if len(filtered.Steps) == 0 && len(p.Steps) != 0 {
+		return nil, fmt.Errorf("no channel of the plan is allowed in '%s'", regdomain)
+	}
+	return filtered, nil
+}
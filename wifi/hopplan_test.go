@@ -0,0 +1,213 @@
+package wifi
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewHopPlan(t *testing.T) {
+	cases := []struct {
+		conf  HopPlanConfig
+		steps int
+	}{
+		{HopPlanConfig{}, 13},
+		{HopPlanConfig{Regdomain: "us"}, 11},
+		{HopPlanConfig{Regdomain: "JP"}, 14},
+		{HopPlanConfig{Bands: Band2GHz | Band5GHz, Regdomain: "DE"}, 13 + 19},
+		{HopPlanConfig{Bands: Band6GHz, Regdomain: "US"}, 59},
+		{HopPlanConfig{Bands: Band6GHz, Regdomain: "CN"}, 0},
+	}
+	for _, c := range cases {
+		plan, err := NewHopPlan(c.conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Steps) != c.steps {
+			t.Errorf("%+v: %d steps, expected %d", c.conf, len(plan.Steps), c.steps)
+		}
+	}
+	wide, err := NewHopPlan(HopPlanConfig{Bands: Band2GHz | Band5GHz, Width: Width80, Regdomain: "US"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	widths := map[int]ChannelWidth{1: Width40, 36: Width80, 144: Width80, 165: Width20}
+	for _, step := range wide.Steps {
+		if w, ok := widths[step.Channel]; ok && step.Width != w {
+			t.Errorf("%v: expected %dMHz", step, w.MHz())
+		}
+	}
+	if _, err := NewHopPlan(HopPlanConfig{Regdomain: "XX"}); err == nil {
+		t.Error("unknown regdomain accepted")
+	}
+}
+
+func TestParseHopPlan(t *testing.T) {
+	plan, err := ParseHopPlan("1, 6,11:2s,36/80:500ms,5975,144")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []HopStep{
+		{ChannelSpec{Band: Band2GHz, Channel: 1}, DwellDefault},
+		{ChannelSpec{Band: Band2GHz, Channel: 6}, DwellDefault},
+		{ChannelSpec{Band: Band2GHz, Channel: 11}, 2 * time.Second},
+		{ChannelSpec{Band: Band5GHz, Channel: 36, Width: Width80}, 500 * time.Millisecond},
+		{ChannelSpec{Band: Band6GHz, Channel: 5}, DwellDefault},
+		{ChannelSpec{Band: Band5GHz, Channel: 144}, DwellDefault},
+	}
+	if len(plan.Steps) != len(expected) {
+		t.Fatalf("got %v", plan.Steps)
+	}
+	for i := range expected {
+		if plan.Steps[i] != expected[i] {
+			t.Errorf("step %d: got %v, expected %v", i, plan.Steps[i], expected[i])
+		}
+	}
+	eu, err := plan.Filter("FR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eu.Steps) != 5 { // no 5GHz channel 144 in europe
+		t.Errorf("filtered: %v", eu.Steps)
+	}
+	sixGHz, _ := ParseHopPlan("5975")
+	if _, err = sixGHz.Filter("00"); err == nil {
+		t.Error("filtered to an empty plan")
+	}
+	for _, bad := range []string{"15", "1/30", "6/80", "165/80", "6:soon", "x"} {
+		if _, err := ParseHopPlan(bad); err == nil {
+			t.Errorf("'%s' accepted", bad)
+		}
+	}
+}
+
+// records every channel it is asked to tune to
+type recordingSetter struct {
+	mtx   sync.Mutex
+	tuned []int
+}
+
+func (r *recordingSetter) SetChannel(ifname string, spec ChannelSpec) error {
+	r.mtx.Lock()
+	r.tuned = append(r.tuned, spec.Channel)
+	r.mtx.Unlock()
+	return nil
+}
+
+func (r *recordingSetter) since(n int) []int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]int(nil), r.tuned[n:]...)
+}
+
+func TestHopperPinAndPause(t *testing.T) {
+	plan, _ := ParseHopPlan("1:5ms,6:5ms,11:5ms")
+	setter := &recordingSetter{}
+	h := NewHopper("wlan0", plan, setter)
+	if _, ok := h.Current(); ok {
+		t.Error("tuned before running")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.Run(ctx)
+
+	time.Sleep(50 * time.Millisecond)
+	hopped := setter.since(0)
+	if len(hopped) < 3 || hopped[0] != 1 || hopped[1] != 6 || hopped[2] != 11 {
+		t.Errorf("not following the plan: %v", hopped)
+	}
+
+	h.Pin(ChannelSpec{Band: Band2GHz, Channel: 3})
+	time.Sleep(10 * time.Millisecond)
+	if current, ok := h.Current(); !ok || current.Channel != 3 {
+		t.Errorf("current channel: %v, %v", current, ok)
+	}
+	n := len(setter.since(0))
+	time.Sleep(30 * time.Millisecond)
+	if pinned := setter.since(n - 1); len(pinned) != 1 || pinned[0] != 3 {
+		t.Errorf("hopping while pinned: %v", pinned)
+	}
+
+	h.Unpin()
+	h.Pause()
+	time.Sleep(10 * time.Millisecond)
+	n = len(setter.since(0))
+	time.Sleep(30 * time.Millisecond)
+	if paused := setter.since(n); len(paused) != 0 {
+		t.Errorf("hopping while paused: %v", paused)
+	}
+
+	h.Resume()
+	time.Sleep(20 * time.Millisecond)
+	if resumed := setter.since(n); len(resumed) == 0 {
+		t.Error("not resumed")
+	}
+}
+
+func TestHopperTunedAt(t *testing.T) {
+	h := NewHopper("wlan0", nil, &recordingSetter{})
+	before := time.Now()
+	h.tune(ChannelSpec{Band: Band2GHz, Channel: 1})
+	on1 := time.Now()
+	h.tune(ChannelSpec{Band: Band2GHz, Channel: 6})
+	for _, tc := range []struct {
+		at      time.Time
+		channel int
+	}{
+		{before.Add(-time.Second), 0},
+		{on1, 1},
+		{time.Now(), 6},
+	} {
+		spec, ok := h.TunedAt(tc.at)
+		if spec.Channel != tc.channel || ok != (tc.channel != 0) {
+			t.Errorf("at %v: got %v, %v, want %d", tc.at, spec, ok, tc.channel)
+		}
+	}
+	for i := 0; i < tuneHistoryLen; i++ {
+		h.tune(ChannelSpec{Band: Band2GHz, Channel: 11})
+	}
+	if len(h.history) != tuneHistoryLen {
+		t.Errorf("%d switches kept", len(h.history))
+	}
+	if _, ok := h.TunedAt(on1); ok {
+		t.Error("beyond the history")
+	}
+}
+
+func TestNilHopPlan(t *testing.T) {
+	n := len(DefaultHopPlan().Steps)
+	if stats := NewRoundRobin(nil).Stats(); len(stats) != n {
+		t.Errorf("round robin: %d channels", len(stats))
+	}
+	h := NewHopper("wlan0", nil, &recordingSetter{})
+	h.SetPlan(nil)
+	if stats := h.Strategy().Stats(); len(stats) != n {
+		t.Errorf("hopper: %d channels", len(stats))
+	}
+	if _, ok := h.Strategy().Next(); !ok {
+		t.Error("nothing to hop")
+	}
+}
+
+func TestHopperKeepsDwelling(t *testing.T) {
+	plan, _ := ParseHopPlan("1:100ms,6:100ms")
+	setter := &recordingSetter{}
+	h := NewHopper("wlan0", plan, setter)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.Run(ctx)
+
+	time.Sleep(10 * time.Millisecond)
+	h.Pause()
+	h.Resume()
+	h.SetPlan(plan)
+	time.Sleep(40 * time.Millisecond)
+	if hopped := setter.since(0); len(hopped) != 1 {
+		t.Errorf("dwell cut short: %v", hopped)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if hopped := setter.since(0); len(hopped) < 2 || hopped[1] != 1 {
+		t.Errorf("new plan not started: %v", hopped)
+	}
+}
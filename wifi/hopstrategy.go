@@ -0,0 +1,235 @@
+package wifi
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Decides where the Hopper goes next, fed with the frames we hear.
+// Implementations must be safe for concurrent use.
+type HopStrategy interface {
+	// start over with a new plan, DefaultHopPlan() if nil
+	Reset(plan *HopPlan)
+	// the step to take after the current one, ok is false if the plan is
+	// empty
+	Next() (step HopStep, ok bool)
+	// an interesting frame was received on <freq> MHz
+	Observe(freq int)
+	// per channel statistics, in plan order
+	Stats() []ChannelStat
+}
+
+type ChannelStat struct {
+	ChannelSpec
+	Frames uint64
+	Visits uint64
+	// the dwell time used on the last visit
+	Dwell time.Duration
+	// frames per second, smoothed
+	Rate float64
+}
+
+func (c ChannelStat) String() string {
+	return fmt.Sprintf("%d:{frames:%d visits:%d dwell:%v rate:%.1f/s}",
+		c.Channel, c.Frames, c.Visits, c.Dwell, c.Rate)
+}
+
+// bookkeeping shared by the strategies, callers hold mtx
+type channelStats struct {
+	mtx     sync.Mutex
+	plan    *HopPlan
+	stats   []ChannelStat
+	current int // index into plan.Steps, -1 before the first Next()
+	started time.Time
+	// frames seen during the current visit
+	frames uint64
+}
+
+func (cs *channelStats) reset(plan *HopPlan) {
+	if plan == nil {
+		plan = DefaultHopPlan()
+	}
+	cs.plan = plan
+	cs.stats = make([]ChannelStat, len(plan.Steps))
+	for i, step := range plan.Steps {
+		cs.stats[i].ChannelSpec = step.ChannelSpec
+	}
+	cs.current = -1
+	cs.frames = 0
+}
+
+// account frames to the step matching <freq>, or the current one if the
+// driver reports nonsense
+func (cs *channelStats) observe(freq int) {
+	for i := range cs.stats {
+		if cs.stats[i].Freq() == freq {
+			cs.stats[i].Frames++
+			if i == cs.current {
+				cs.frames++
+			}
+			return
+		}
+	}
+	if cs.current >= 0 {
+		cs.stats[cs.current].Frames++
+		cs.frames++
+	}
+}
+
+// finish the current visit, updating its smoothed rate with weight
+// 1-<decay>, and move to step <next>
+func (cs *channelStats) advance(next int, dwell time.Duration, decay float64) {
+	now := time.Now()
+	if cs.current >= 0 {
+		if elapsed := now.Sub(cs.started).Seconds(); elapsed > 0 {
+			st := &cs.stats[cs.current]
+			st.Rate = decay*st.Rate + (1-decay)*float64(cs.frames)/elapsed
+		}
+	}
+	cs.current = next
+	cs.started = now
+	cs.frames = 0
+	cs.stats[next].Visits++
+	cs.stats[next].Dwell = dwell
+}
+
+func (cs *channelStats) snapshot() []ChannelStat {
+	return append([]ChannelStat(nil), cs.stats...)
+}
+
+// the plan as it is, in order
+type RoundRobin struct {
+	channelStats
+}
+
+// DefaultHopPlan() if <plan> is nil
+func NewRoundRobin(plan *HopPlan) *RoundRobin {
+	rr := &RoundRobin{}
+	rr.reset(plan)
+	return rr
+}
+
+func (rr *RoundRobin) Reset(plan *HopPlan) {
+	rr.mtx.Lock()
+	rr.reset(plan)
+	rr.mtx.Unlock()
+}
+
+func (rr *RoundRobin) Next() (HopStep, bool) {
+	rr.mtx.Lock()
+	defer rr.mtx.Unlock()
+	if len(rr.plan.Steps) == 0 {
+		return HopStep{}, false
+	}
+	next := (rr.current + 1) % len(rr.plan.Steps)
+	step := rr.plan.Steps[next]
+	rr.advance(next, step.Dwell, 0)
+	return step, true
+}
+
+func (rr *RoundRobin) Observe(freq int) {
+	rr.mtx.Lock()
+	rr.observe(freq)
+	rr.mtx.Unlock()
+}
+
+func (rr *RoundRobin) Stats() []ChannelStat {
+	rr.mtx.Lock()
+	defer rr.mtx.Unlock()
+	return rr.snapshot()
+}
+
+const AdaptiveMinDwellDefault = time.Millisecond * 200
+const AdaptiveMaxDwellDefault = time.Second * 5
+const AdaptiveDecayDefault = 0.5
+
+// can be passed empty, sane defaults will be choosen
+type AdaptiveConfig struct {
+	// every channel gets at least this much per round, so quiet ones are
+	// still sampled
+	MinDwell time.Duration
+	MaxDwell time.Duration
+	// weight of the history when updating a channels rate, in [0, 1)
+	Decay float64
+}
+
+// Visits every channel of the plan once per round, like RoundRobin, but
+// redistributes the rounds time budget (the sum of the plans dwell times)
+// proportional to the frame rate seen on each channel.
+type Adaptive struct {
+	AdaptiveConfig
+	channelStats
+}
+
+func NewAdaptive(plan *HopPlan, conf AdaptiveConfig) *Adaptive {
+	if conf.MinDwell == 0 {
+		conf.MinDwell = AdaptiveMinDwellDefault
+	}
+	if conf.MaxDwell == 0 {
+		conf.MaxDwell = AdaptiveMaxDwellDefault
+	}
+	if conf.Decay == 0 {
+		conf.Decay = AdaptiveDecayDefault
+	}
+	a := &Adaptive{AdaptiveConfig: conf}
+	a.reset(plan)
+	return a
+}
+
+func (a *Adaptive) Reset(plan *HopPlan) {
+	a.mtx.Lock()
+	a.reset(plan)
+	a.mtx.Unlock()
+}
+
+func (a *Adaptive) Next() (HopStep, bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if len(a.plan.Steps) == 0 {
+		return HopStep{}, false
+	}
+	next := (a.current + 1) % len(a.plan.Steps)
+	step := a.plan.Steps[next]
+	step.Dwell = a.dwell(next)
+	a.advance(next, step.Dwell, a.Decay)
+	return step, true
+}
+
+// dwell time for step <i>, callers hold mtx
+func (a *Adaptive) dwell(i int) time.Duration {
+	var budget time.Duration
+	var total float64
+	for j, step := range a.plan.Steps {
+		if step.Dwell == 0 {
+			step.Dwell = DwellDefault
+		}
+		budget += step.Dwell
+		total += a.stats[j].Rate
+	}
+	if total == 0 {
+		// nothing heard yet, nothing to adapt to
+		return a.plan.Steps[i].Dwell
+	}
+	spare := budget - time.Duration(len(a.plan.Steps))*a.MinDwell
+	if spare < 0 {
+		spare = 0
+	}
+	dwell := a.MinDwell + time.Duration(float64(spare)*a.stats[i].Rate/total)
+	if dwell > a.MaxDwell {
+		dwell = a.MaxDwell
+	}
+	return dwell
+}
+
+func (a *Adaptive) Observe(freq int) {
+	a.mtx.Lock()
+	a.observe(freq)
+	a.mtx.Unlock()
+}
+
+func (a *Adaptive) Stats() []ChannelStat {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.snapshot()
+}
@@ -0,0 +1,62 @@
+package wifi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoundRobinFollowsPlan(t *testing.T) {
+	plan, _ := ParseHopPlan("1,6,11")
+	rr := NewRoundRobin(plan)
+	for i := 0; i < 6; i++ {
+		step, ok := rr.Next()
+		if !ok || step != plan.Steps[i%3] {
+			t.Errorf("step %d: got %v, expected %v", i, step, plan.Steps[i%3])
+		}
+		rr.Observe(step.Freq())
+	}
+	for _, st := range rr.Stats() {
+		if st.Visits != 2 || st.Frames != 2 {
+			t.Errorf("%v: expected 2 visits + frames", st)
+		}
+	}
+	rr.Reset(&HopPlan{})
+	if _, ok := rr.Next(); ok {
+		t.Error("next step of an empty plan")
+	}
+}
+
+func TestAdaptivePrefersBusyChannels(t *testing.T) {
+	plan, _ := ParseHopPlan("1,6,11")
+	a := NewAdaptive(plan, AdaptiveConfig{})
+
+	// first round, nothing known until channel 1 is done
+	for i := 0; i < 3; i++ {
+		step, _ := a.Next()
+		if i < 2 && step.Dwell != DwellDefault {
+			t.Errorf("%v: unexpected dwell", step)
+		}
+		if step.Channel == 1 {
+			for j := 0; j < 100; j++ {
+				a.Observe(2412)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	// all spare time of the round goes to channel 1
+	expected := map[int]time.Duration{
+		1:  AdaptiveMinDwellDefault + 3*DwellDefault - 3*AdaptiveMinDwellDefault,
+		6:  AdaptiveMinDwellDefault,
+		11: AdaptiveMinDwellDefault,
+	}
+	for i := 0; i < 3; i++ {
+		step, _ := a.Next()
+		if step.Dwell != expected[step.Channel] {
+			t.Errorf("%v: expected dwell %v", step, expected[step.Channel])
+		}
+	}
+	if st := a.Stats()[0]; st.Frames != 100 || st.Rate <= 0 {
+		t.Errorf("channel 1: %v", st)
+	}
+}
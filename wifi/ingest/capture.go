@@ -0,0 +1,151 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/tinygoprogs/sigint/wifi"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// what directories are searched for, optionally with a ".gz" suffix
+var Extensions = []string{".pcap", ".pcapng", ".cap"}
+
+var ngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Files named by <args>, which are file names, globs or directories.
+// Directories are searched recursively for Extensions. Every file is
+// returned once, in the order given, directories sorted.
+func Expand(args []string) (files []string, err error) {
+	seen := map[string]bool{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("%s: %v", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no matches", arg)
+			}
+		}
+		for _, path := range matches {
+			var fi os.FileInfo
+			if fi, err = os.Stat(path); err != nil {
+				return nil, err
+			}
+			if !fi.IsDir() {
+				add(path)
+				continue
+			}
+			var found []string
+			err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+				if err == nil && !fi.IsDir() && isCapture(p) {
+					found = append(found, p)
+				}
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			sort.Strings(found)
+			for _, p := range found {
+				add(p)
+			}
+		}
+	}
+	return
+}
+
+func isCapture(path string) bool {
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(path, ".gz")))
+	for _, e := range Extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// A capture file opened for reading, pcap or pcapng, optionally gzipped.
+type Capture struct {
+	wifi.PacketSource
+	f    *os.File
+	size int64
+	read int64 // of f, atomic
+}
+
+func Open(path string) (*Capture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Capture{f: f}
+	if fi, err := f.Stat(); err == nil {
+		c.size = fi.Size()
+	}
+	if c.PacketSource, err = c.open(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Capture) open() (wifi.PacketSource, error) {
+	var r io.Reader = bufio.NewReader(&counter{c.f, &c.read})
+	magic, err := r.(*bufio.Reader).Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(magic, gzipMagic) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = bufio.NewReader(gz)
+		if magic, err = r.(*bufio.Reader).Peek(4); err != nil {
+			return nil, err
+		}
+	}
+	if bytes.Equal(magic, ngMagic) {
+		return pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+	}
+	return pcapgo.NewReader(r)
+}
+
+// fraction of the file read so far, in [0, 1]
+func (c *Capture) Progress() float64 {
+	if c.size == 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&c.read)) / float64(c.size)
+}
+
+func (c *Capture) Close() error {
+	return c.f.Close()
+}
+
+// counts the bytes read, for Progress()
+type counter struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *counter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,91 @@
+// Replay capture files into a store, after the fact.
+//
+// Datapoints keep the time they were captured, re-ingesting a file is
+// harmless since the store ignores datapoints it already has (unique_dps).
+package ingest
+
+import (
+	"context"
+	"github.com/tinygoprogs/sigint/wifi"
+	"log"
+	"time"
+)
+
+const ProgressEveryDefault = time.Second * 10
+
+// can be passed empty, sane defaults will be choosen
+type Config struct {
+	// used for every file, Source and Block are set per file. Location should
+	// be a track covering the captures, see location.LoadTrack
+	Wifi wifi.WifiConfig
+	// log progress this often
+	ProgressEvery time.Duration
+}
+
+// where devices go, e.g. a local.Store
+type Sink interface {
+	NewDevices(ctx context.Context, devs *wifi.Devices) (*wifi.Ack, error)
+}
+
+type Stats struct {
+	Files, Failed       int
+	Devices, DataPoints int
+}
+
+// Replay <files> one after another into <sink>. Files that can't be read are
+// logged and skipped, only a Done() ctx stops early.
+func Ingest(ctx context.Context, sink Sink, files []string, conf Config) (stats Stats, err error) {
+	if conf.ProgressEvery == 0 {
+		conf.ProgressEvery = ProgressEveryDefault
+	}
+	start := time.Now()
+	for i, file := range files {
+		var c *Capture
+		if c, err = Open(file); err != nil {
+			log.Printf("skipping: %v", err)
+			stats.Failed++
+			err = nil
+			continue
+		}
+		log.Printf("[%d/%d] %s", i+1, len(files), file)
+		before := stats
+		stats, err = ingest(ctx, sink, c, conf, stats, func(s Stats) {
+			log.Printf("[%d/%d] %s: %.0f%%, %d devices, %d datapoints", i+1, len(files), file,
+				c.Progress()*100, s.Devices-before.Devices, s.DataPoints-before.DataPoints)
+		})
+		c.Close()
+		if err != nil {
+			return
+		}
+		stats.Files++
+	}
+	log.Printf("ingested %d files (%d failed) in %v: %d devices, %d datapoints",
+		stats.Files, stats.Failed, time.Since(start), stats.Devices, stats.DataPoints)
+	return
+}
+
+func ingest(ctx context.Context, sink Sink, c *Capture, conf Config, stats Stats, progress func(Stats)) (Stats, error) {
+	wconf := conf.Wifi
+	wconf.Interface, wconf.Handle, wconf.Source, wconf.Block = "", nil, c, true
+	devices := wifi.NewWifi(wconf).Start(ctx)
+	tick := time.NewTicker(conf.ProgressEvery)
+	defer tick.Stop()
+	for {
+		select {
+		case dev, ok := <-devices:
+			if !ok {
+				progress(stats)
+				return stats, ctx.Err()
+			}
+			_, err := sink.NewDevices(ctx, &wifi.Devices{Devices: []*wifi.Device{dev}})
+			if err != nil {
+				log.Printf("failed to store: %v, %v", dev, err)
+				continue
+			}
+			stats.Devices++
+			stats.DataPoints += len(dev.DataPoints)
+		case <-tick.C:
+			progress(stats)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package ingest
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"flag"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/tinygoprogs/sigint/wifi"
+	"github.com/tinygoprogs/sigint/wifi/local"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func init() {
+	flag.Parse()
+	log.SetFlags(log.Lshortfile | log.Lmicroseconds)
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+}
+
+var epoch = time.Date(2020, 3, 1, 20, 0, 0, 0, time.UTC)
+
+// radiotap with frequency and signal, then a probe request from <sa>
+func probeRequest(t *testing.T, sa string) []byte {
+	addr, err := net.ParseMAC(sa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := []byte{0, 0, 13, 0, 0x28, 0, 0, 0, 0, 0, 0xa0, 0, 0xc4}
+	binary.LittleEndian.PutUint16(rt[8:], 2412)
+	buf := gopacket.NewSerializeBuffer()
+	err = gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, &layers.Dot11{
+		Type:     layers.Dot11TypeMgmtProbeReq,
+		Address1: layers.EthernetBroadcast,
+		Address2: addr,
+		Address3: layers.EthernetBroadcast,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(rt, buf.Bytes()...)
+}
+
+// <n> probe requests of different devices, a second apart from <first>
+func writeCapture(t *testing.T, path string, ng bool, first time.Time, n int) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if filepath.Ext(path) == ".gz" {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	var write func(ci gopacket.CaptureInfo, data []byte) error
+	if ng {
+		ngw, err := pcapgo.NewNgWriter(w, layers.LinkTypeIEEE80211Radio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ngw.Flush()
+		write = ngw.WritePacket
+	} else {
+		pw := pcapgo.NewWriterNanos(w)
+		if err = pw.WriteFileHeader(0xffff, layers.LinkTypeIEEE80211Radio); err != nil {
+			t.Fatal(err)
+		}
+		write = pw.WritePacket
+	}
+	for i := 0; i < n; i++ {
+		data := probeRequest(t, net.HardwareAddr{0, 0x1b, 0x63, 0, 0, byte(i)}.String())
+		ci := gopacket.CaptureInfo{Timestamp: first.Add(time.Duration(i) * time.Second), CaptureLength: len(data), Length: len(data)}
+		if err = write(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.pcap", "b.pcapng.gz", "sub/c.cap", "notes.txt"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err = ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := Expand([]string{filepath.Join(dir, "a.pcap"), dir, filepath.Join(dir, "*.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.pcap", "b.pcapng.gz", "sub/c.cap", "notes.txt"}
+	if len(files) != len(want) {
+		t.Fatalf("got %v", files)
+	}
+	for i := range want {
+		if files[i] != filepath.Join(dir, want[i]) {
+			t.Errorf("got %v, want %v", files, want)
+			break
+		}
+	}
+	if _, err = Expand([]string{filepath.Join(dir, "*.nope")}); err == nil {
+		t.Error("glob without matches accepted")
+	}
+}
+
+func ingestInto(t *testing.T, db string, files []string) Stats {
+	ctx, cancel := context.WithCancel(context.Background())
+	st, err := local.NewLStore(ctx, &local.LocalConfig{File: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := Ingest(context.Background(), st, files, Config{})
+	cancel()
+	st.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestIngest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeCapture(t, filepath.Join(dir, "1.pcap"), false, epoch, 10)
+	writeCapture(t, filepath.Join(dir, "2.pcapng.gz"), true, epoch.Add(time.Hour), 5)
+	ioutil.WriteFile(filepath.Join(dir, "3.pcap"), []byte("garbage"), 0600)
+	files, err := Expand([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := filepath.Join(dir, "ingest.db")
+
+	stats := ingestInto(t, db, files)
+	if stats.Files != 2 || stats.Failed != 1 || stats.Devices != 15 || stats.DataPoints != 15 {
+		t.Errorf("got %+v", stats)
+	}
+	// nothing new the second time
+	ingestInto(t, db, files)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st, err := local.NewLStore(ctx, &local.LocalConfig{File: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		st.Wait()
+	}()
+	res, err := st.Query(ctx, &wifi.SightingQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Sightings) != 15 {
+		t.Fatalf("%d sightings", len(res.Sightings))
+	}
+	first, last := res.Sightings[0].DataPoint, res.Sightings[14].DataPoint
+	if first.TimeStamp != uint64(epoch.UnixNano()) || int8(first.Signal) != -60 || first.Frequency != 2412 {
+		t.Errorf("first: %v", first)
+	}
+	if last.TimeStamp != uint64(epoch.Add(time.Hour+4*time.Second).UnixNano()) {
+		t.Errorf("last: %v", last)
+	}
+}
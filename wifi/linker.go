@@ -0,0 +1,165 @@
+package wifi
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+)
+
+const LinkerMinGapDefault = time.Second
+const LinkerMaxGapDefault = time.Minute * 5
+const LinkerMaxSeqGapDefault = 64
+const LinkerExpireDefault = time.Minute * 30
+
+// 802.11 sequence numbers are 12 bit
+const seqModulo = 1 << 12
+
+// the U/L bit, phones rotating their MAC set it, multicast addresses are
+// never a station
+func IsLocallyAdministered(addr net.HardwareAddr) bool {
+	return len(addr) > 0 && addr[0]&0x02 != 0 && addr[0]&0x01 == 0
+}
+
+// can be passed empty, sane defaults will be choosen
+type LinkerConfig struct {
+	// a MAC must have been silent at least this long to be continued by a
+	// new one, devices transmitting concurrently are different ones
+	MinGap time.Duration
+	// a MAC must have been silent at most this long to be continued by a new
+	// one
+	MaxGap time.Duration
+	// max distance between the last sequence number of the old and the first
+	// of the new MAC
+	MaxSeqGap int
+	// forget about clusters idle for this long
+	Expire time.Duration
+}
+
+// Clusters randomized MACs belonging to the same physical device.
+//
+// A new randomized MAC continues an existing cluster if the cluster went
+// quiet shortly before and the sequence numbers continue where the old MAC
+// stopped. Matching probe request fingerprints break ties, devices of the same
+// model share them. Conflicting fingerprints never link.
+type Linker struct {
+	LinkerConfig
+	mtx       sync.Mutex
+	byMAC     map[string]*cluster
+	lastSweep time.Time
+}
+
+type cluster struct {
+	id       string
+	digest   string
+	mac      string // the most recent address
+	lastSeen time.Time
+	lastSeq  int
+}
+
+func NewLinker(conf LinkerConfig) *Linker {
+	if conf.MinGap == 0 {
+		conf.MinGap = LinkerMinGapDefault
+	}
+	if conf.MaxGap == 0 {
+		conf.MaxGap = LinkerMaxGapDefault
+	}
+	if conf.MaxSeqGap == 0 {
+		conf.MaxSeqGap = LinkerMaxSeqGapDefault
+	}
+	if conf.Expire == 0 {
+		conf.Expire = LinkerExpireDefault
+	}
+	return &Linker{
+		LinkerConfig: conf,
+		byMAC:        make(map[string]*cluster),
+	}
+}
+
+// set dev.PseudoID, globally administered addresses are their own pseudo
+// device
+func (l *Linker) Link(dev *Device) {
+	dps := dev.GetDataPoints()
+	if !dev.Randomized || len(dps) == 0 {
+		dev.PseudoID = dev.MAC
+		return
+	}
+	last := dps[len(dps)-1]
+	seen := time.Unix(0, int64(last.TimeStamp))
+	seq := int(last.Sequence)
+	var digest string
+	if dev.Fingerprint != nil {
+		digest = dev.Fingerprint.Digest()
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.sweep(seen)
+	c, ok := l.byMAC[dev.MAC]
+	if !ok {
+		c = l.successorOf(seen, seq, digest)
+		if c == nil {
+			c = &cluster{id: pseudoID(dev.MAC, digest)}
+		}
+		c.mac = dev.MAC
+		l.byMAC[dev.MAC] = c
+	}
+	if seen.After(c.lastSeen) {
+		c.lastSeen = seen
+		c.lastSeq = seq
+	}
+	if c.digest == "" {
+		c.digest = digest
+	}
+	dev.PseudoID = c.id
+}
+
+// find the best cluster a new MAC seen at <seen> could continue, or nil
+func (l *Linker) successorOf(seen time.Time, seq int, digest string) (best *cluster) {
+	var bestScore int
+	var bestGap time.Duration
+	for mac, c := range l.byMAC {
+		if mac != c.mac {
+			continue // an old address of c, visit c only once
+		}
+		gap := seen.Sub(c.lastSeen)
+		if gap < l.MinGap || gap > l.MaxGap {
+			continue // still active or too long ago
+		}
+		if seqGap := (seq - c.lastSeq + seqModulo) % seqModulo; seqGap == 0 || seqGap > l.MaxSeqGap {
+			continue
+		}
+		score := 1
+		if digest != "" && c.digest != "" {
+			if digest != c.digest {
+				continue
+			}
+			score++
+		}
+		if score > bestScore || (score == bestScore && gap < bestGap) {
+			best, bestScore, bestGap = c, score, gap
+		}
+	}
+	return
+}
+
+// forget idle clusters, at most once per Expire
+func (l *Linker) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.Expire {
+		return
+	}
+	l.lastSweep = now
+	for mac, c := range l.byMAC {
+		if now.Sub(c.lastSeen) > l.Expire {
+			delete(l.byMAC, mac)
+		}
+	}
+}
+
+// derived from the first MAC of a cluster, so rerunning on the same capture
+// gives the same ids
+func pseudoID(mac, digest string) string {
+	sum := sha1.Sum([]byte(mac + digest))
+	return "rnd-" + hex.EncodeToString(sum[:6])
+}
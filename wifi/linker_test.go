@@ -0,0 +1,97 @@
+package wifi
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+var linkerEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func randomizedDev(mac string, after time.Duration, seq uint32, fp *Fingerprint) *Device {
+	return &Device{
+		MAC:         mac,
+		Randomized:  true,
+		Fingerprint: fp,
+		DataPoints: []*DataPoint{
+			&DataPoint{TimeStamp: uint64(linkerEpoch.Add(after).UnixNano()), Sequence: seq},
+		},
+	}
+}
+
+func TestIsLocallyAdministered(t *testing.T) {
+	cases := map[string]bool{
+		"02:00:00:00:00:01": true,
+		"da:a1:19:00:00:01": true,
+		"00:1b:63:00:00:01": false,
+		"03:00:00:00:00:01": false, // multicast
+	}
+	for mac, expected := range cases {
+		addr, _ := net.ParseMAC(mac)
+		if IsLocallyAdministered(addr) != expected {
+			t.Errorf("%s: expected %v", mac, expected)
+		}
+	}
+}
+
+func TestLinkerLinksRotatedMACs(t *testing.T) {
+	phone := &Fingerprint{Rates: []byte{2, 4, 11, 22}, IEIDs: []byte{0, 1, 50}}
+	other := &Fingerprint{Rates: []byte{2, 4}, IEIDs: []byte{0, 1}}
+	l := NewLinker(LinkerConfig{})
+
+	first := randomizedDev("02:00:00:00:00:01", 0, 100, phone)
+	l.Link(first)
+
+	cases := []struct {
+		what string
+		dev  *Device
+		same bool
+	}{
+		{"same MAC", randomizedDev("02:00:00:00:00:01", time.Second, 101, nil), true},
+		{"matching fingerprint", randomizedDev("02:00:00:00:00:02", time.Minute, 110, phone), true},
+		{"sequence continues", randomizedDev("02:00:00:00:00:03", 2*time.Minute, 120, nil), true},
+		{"still active", randomizedDev("02:00:00:00:00:04", 2*time.Minute+time.Millisecond*10, 121, phone), false},
+		{"sequence jumps", randomizedDev("02:00:00:00:00:05", 3*time.Minute, 3000, phone), false},
+		{"other fingerprint", randomizedDev("02:00:00:00:00:06", 4*time.Minute, 3010, other), false},
+		{"too long ago", randomizedDev("02:00:00:00:00:07", time.Hour, 3012, phone), false},
+	}
+	for _, c := range cases {
+		l.Link(c.dev)
+		if (c.dev.PseudoID == first.PseudoID) != c.same {
+			t.Errorf("%s: got %v, first is %v", c.what, c.dev.PseudoID, first.PseudoID)
+		}
+	}
+}
+
+// two phones of the same model probing at the same time
+func TestLinkerKeepsConcurrentDevicesApart(t *testing.T) {
+	phone := &Fingerprint{Rates: []byte{2, 4, 11, 22}, IEIDs: []byte{0, 1, 50}}
+	l := NewLinker(LinkerConfig{})
+	a := randomizedDev("02:00:00:00:00:0a", 0, 100, phone)
+	b := randomizedDev("02:00:00:00:00:0b", time.Millisecond*100, 102, phone)
+	l.Link(a)
+	l.Link(b)
+	if a.PseudoID == b.PseudoID {
+		t.Fatal("concurrent devices linked")
+	}
+	for i := 1; i < 10; i++ {
+		after := time.Duration(i) * time.Millisecond * 200
+		l.Link(randomizedDev(a.MAC, after, uint32(100+i), phone))
+		l.Link(randomizedDev(b.MAC, after+time.Millisecond*100, uint32(2000+i), phone))
+	}
+	// a rotates its MAC, b keeps on probing
+	rotated := randomizedDev("02:00:00:00:00:0c", time.Second*5, 112, phone)
+	l.Link(rotated)
+	if rotated.PseudoID != a.PseudoID {
+		t.Errorf("rotated MAC not linked to a")
+	}
+}
+
+func TestLinkerKeepsGlobalMACs(t *testing.T) {
+	dev := randomizedDev("00:1b:63:00:00:01", 0, 0, nil)
+	dev.Randomized = false
+	NewLinker(LinkerConfig{}).Link(dev)
+	if dev.PseudoID != dev.MAC {
+		t.Errorf("got %v, expected %v", dev.PseudoID, dev.MAC)
+	}
+}
@@ -0,0 +1,60 @@
+package local
+
+import (
+	"sync"
+)
+
+type nodeEntry struct {
+	id int64
+	// pseudo_id is set
+	linked bool
+}
+
+// MAC to node id, saves a SELECT per device. Safe for concurrent use.
+type nodeCache struct {
+	mtx   sync.Mutex
+	size  int
+	nodes map[string]nodeEntry
+}
+
+func newNodeCache(size int) *nodeCache {
+	return &nodeCache{size: size, nodes: make(map[string]nodeEntry)}
+}
+
+// changes of a transaction, only visible to others after commit, a rollback
+// would leave us with ids that don't exist
+type nodeTx struct {
+	cache *nodeCache
+	nodes map[string]nodeEntry
+}
+
+func (c *nodeCache) begin() *nodeTx {
+	return &nodeTx{cache: c, nodes: make(map[string]nodeEntry)}
+}
+
+func (c *nodeCache) commit(t *nodeTx) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if len(c.nodes)+len(t.nodes) > c.size {
+		// randomized MACs are rarely seen again, start over instead of
+		// tracking what is used
+		c.nodes = make(map[string]nodeEntry)
+	}
+	for mac, node := range t.nodes {
+		c.nodes[mac] = node
+	}
+}
+
+func (t *nodeTx) get(mac string) (node nodeEntry, ok bool) {
+	if node, ok = t.nodes[mac]; ok {
+		return
+	}
+	t.cache.mtx.Lock()
+	node, ok = t.cache.nodes[mac]
+	t.cache.mtx.Unlock()
+	return
+}
+
+func (t *nodeTx) put(mac string, node nodeEntry) {
+	t.nodes[mac] = node
+}
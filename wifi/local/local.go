@@ -3,25 +3,93 @@ package local
 import (
 	"context"
 	"github.com/tinygoprogs/sigint/wifi"
+	"github.com/tinygoprogs/sigint/wifi/location"
+	"github.com/tinygoprogs/sigint/wifi/metrics"
+	"github.com/tinygoprogs/sigint/wifi/remote"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"log"
 )
 
 type Config struct {
+	// store as configured by LConf, even if Remote is set
 	Local bool
 	LConf LocalConfig
-	Wifi  wifi.WifiConfig
+	// push to the Collector at this address instead, if set
+	Remote string
+	// for Remote, e.g. credentials.NewClientTLSFromFile(). Plaintext if nil
+	RemoteCreds credentials.TransportCredentials
+	RConf       remote.Config
+	Wifi        wifi.WifiConfig
+	// run a location.Provider for Wifi.Location, unless that is set already
+	Location *location.Config
+	// serve Prometheus metrics and expvar at this address, e.g. ":9100".
+	// None if empty
+	Metrics string
 }
 
 // Collect forever, unless an error occurs.
 func Collect(ctx context.Context, conf Config) error {
-	ls, err := NewLStore(ctx, &conf.LConf)
+	if conf.Location != nil && conf.Wifi.Location == nil {
+		p := location.NewProvider(*conf.Location)
+		go p.Run(ctx)
+		conf.Wifi.Location = p
+	}
+	if conf.Remote != "" && !conf.Local {
+		return collectRemote(ctx, conf)
+	}
+	// outlives ctx, so what is sent after capturing stopped (e.g. the last
+	// aggregated windows) is stored
+	store_ctx, stop_store := context.WithCancel(context.Background())
+	defer stop_store()
+	ls, err := NewStore(store_ctx, &conf.LConf)
 	if err != nil {
 		return err
 	}
 	src := wifi.NewWifi(conf.Wifi)
+	serveMetrics(ctx, conf.Metrics, src, ls)
 	devices := src.Start(ctx)
 	for dev := range devices {
 		devs := wifi.Devices{Devices: []*wifi.Device{dev}}
-		ls.NewDevices(ctx, &devs)
+		ls.NewDevices(store_ctx, &devs)
+	}
+	stop_store()
+	ls.Wait()
+	return nil
+}
+
+func collectRemote(ctx context.Context, conf Config) error {
+	creds := grpc.WithInsecure()
+	if conf.RemoteCreds != nil {
+		creds = grpc.WithTransportCredentials(conf.RemoteCreds)
+	}
+	conn, err := grpc.Dial(conf.Remote, creds)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client, err := remote.NewClient(wifi.NewCollectorClient(conn), conf.RConf)
+	if err != nil {
+		return err
 	}
+	src := wifi.NewWifi(conf.Wifi)
+	serveMetrics(ctx, conf.Metrics, src, metrics.Func(func() []metrics.Sample {
+		return []metrics.Sample{metrics.Gauge("sigint_spooled_batches",
+			"batches waiting for the Collector", float64(client.Spooled()))}
+	}))
+	client.Run(ctx, src.Start(ctx))
+	client.Wait()
 	return nil
 }
+
+// in the background, if <addr> is set
+func serveMetrics(ctx context.Context, addr string, cs ...metrics.Collector) {
+	if addr == "" {
+		return
+	}
+	go func() {
+		if err := metrics.Serve(ctx, addr, cs...); err != nil {
+			log.Printf("Error: metrics: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,204 @@
+package local
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// one step up the schema, runs inside a transaction
+type migration struct {
+	desc string
+	up   func(tx *sql.Tx) error
+}
+
+// migrations[i] upgrades from version i+1 to i+2. Version 1 is the schema of
+// the first release, which did not record a version. Only ever append here,
+// and keep createStmts in sync.
+//
+// Databases created between releases may already have some of the changes,
+// so the steps must not fail on existing tables and columns.
+var migrations = []migration{
+	{"randomized MACs, pseudo devices and fingerprints", func(tx *sql.Tx) error {
+		err := addColumns(tx, "nodes",
+			"randomized BOOLEAN DEFAULT false",
+			"pseudo_id STRING")
+		if err != nil {
+			return err
+		}
+		err = addColumns(tx, "datapoints", "sequence INTEGER")
+		if err != nil {
+			return err
+		}
+		return execAll(tx,
+			"CREATE INDEX IF NOT EXISTS nodes_pseudo_id ON nodes(pseudo_id)",
+			createTable("fingerprints", fingerprintsColumns),
+			createTable("probed_ssids", probedSSIDsColumns))
+	}},
+	{"tuned channel and location quality of datapoints", func(tx *sql.Tx) error {
+		return addColumns(tx, "datapoints",
+			"tuned_frequency INTEGER",
+			"tuned_channel INTEGER",
+			"channel_width INTEGER",
+			"altitude REAL",
+			"accuracy REAL",
+			"fix_age INTEGER")
+	}},
+	{"humans with any number of devices", migrateHumans},
+	{"index datapoints by node, for queries", func(tx *sql.Tx) error {
+		return execAll(tx, "CREATE INDEX IF NOT EXISTS datapoints_node_id ON datapoints(node_id, time)")
+	}},
+	{"summaries of aggregated datapoints", func(tx *sql.Tx) error {
+		return execAll(tx, createTable("summaries", summariesColumns))
+	}},
+}
+
+func latestVersion() int {
+	return len(migrations) + 1
+}
+
+// bring <db> to latestVersion(), empty databases are created from scratch
+func migrate(db *sql.DB) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > latestVersion() {
+		return fmt.Errorf("database schema version %d is newer than this binary (%d), refusing to touch it",
+			version, latestVersion())
+	}
+	if version == 0 {
+		return inTx(db, latestVersion(), func(tx *sql.Tx) error {
+			return execAll(tx, createStmts()...)
+		})
+	}
+	for ; version < latestVersion(); version++ {
+		m := migrations[version-1]
+		log.Printf("migrating database schema to version %d: %s", version+1, m.desc)
+		err = inTx(db, version+1, m.up)
+		if err != nil {
+			return fmt.Errorf("migration to version %d failed: %v", version+1, err)
+		}
+	}
+	return nil
+}
+
+// 0 for empty databases
+func schemaVersion(db *sql.DB) (version int, err error) {
+	err = db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil || version != 0 {
+		return
+	}
+	var n int
+	err = db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'nodes'").Scan(&n)
+	if n != 0 {
+		// from before we had versions
+		version = 1
+	}
+	return
+}
+
+// run <f> and record <version>, all or nothing
+func inTx(db *sql.DB, version int, f func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = f(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func execAll(tx *sql.Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createTable(name, columns string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY, %s)", name, columns)
+}
+
+func columnNames(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := map[string]bool{}
+	for rows.Next() {
+		var (
+			cid, notnull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err = rows.Scan(&cid, &name, &typ, &notnull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
+
+// "<name> <type...>" definitions, existing columns are skipped
+func addColumns(tx *sql.Tx, table string, columns ...string) error {
+	existing, err := columnNames(tx, table)
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if existing[strings.Fields(column)[0]] {
+			continue
+		}
+		_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// from a fixed number of node_id<N> columns in humans to human_devices
+func migrateHumans(tx *sql.Tx) error {
+	columns, err := columnNames(tx, "humans")
+	if err != nil {
+		return err
+	}
+	if !columns["node_id0"] {
+		// already normalized
+		return execAll(tx,
+			createTable("human_devices", humanDevicesColumns),
+			"CREATE INDEX IF NOT EXISTS human_devices_node_id ON human_devices(node_id)")
+	}
+	err = execAll(tx,
+		"ALTER TABLE humans RENAME TO humans_legacy",
+		createTable("humans", humansColumns),
+		createTable("human_devices", humanDevicesColumns),
+		"CREATE INDEX human_devices_node_id ON human_devices(node_id)",
+		"INSERT OR IGNORE INTO humans(name) SELECT name FROM humans_legacy WHERE name IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	for i := 0; columns[fmt.Sprintf("node_id%d", i)]; i++ {
+		_, err = tx.Exec(fmt.Sprintf(`INSERT OR IGNORE
+      INTO human_devices(human_id, node_id, probability, provenance, updated)
+      SELECT h.id, l.node_id%d, 1, 'legacy', 0
+      FROM humans_legacy l JOIN humans h ON h.name = l.name
+      WHERE l.node_id%d IS NOT NULL`, i, i))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DROP TABLE humans_legacy")
+	return err
+}
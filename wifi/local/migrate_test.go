@@ -0,0 +1,201 @@
+package local
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/tinygoprogs/sigint/wifi"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// the schema of the first release, with 2 devices per human
+var legacyStmts = []string{
+	"CREATE TABLE nodes (id INTEGER PRIMARY KEY, addr STRING UNIQUE)",
+	`CREATE TABLE humans (id INTEGER PRIMARY KEY, name STRING, node_id0 INTEGER, node_id1 INTEGER,
+    FOREIGN KEY(node_id0) REFERENCES nodes(id), FOREIGN KEY(node_id1) REFERENCES nodes(id))`,
+	`CREATE TABLE datapoints (id INTEGER PRIMARY KEY, time BLOB, frequency INTEGER, signal INTEGER,
+    longitude INTEGER, latitude INTEGER, node_id INTEGER,
+    FOREIGN KEY(node_id) REFERENCES nodes(id) ON DELETE CASCADE,
+    CONSTRAINT unique_dps UNIQUE (time, node_id, signal))`,
+	"INSERT INTO nodes(addr) VALUES('00:11:22:33:44:55'), ('00:11:22:33:44:66')",
+	"INSERT INTO datapoints(time, frequency, signal, node_id) VALUES(1, 2412, 200, 1)",
+	"INSERT INTO humans(name, node_id0, node_id1) VALUES('alice', 1, 2)",
+}
+
+func tempDB(t *testing.T, stmts ...string) (file string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file = filepath.Join(dir, "test.db")
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range stmts {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return file, func() { os.RemoveAll(dir) }
+}
+
+// table -> column names
+func schema(t *testing.T, file string) map[string][]string {
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT name FROM sqlite_master WHERE type IN ('table', 'index') AND sql IS NOT NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		tables = append(tables, name)
+	}
+	rows.Close()
+	s := map[string][]string{}
+	for _, table := range tables {
+		columns, err := columnNames(tx, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for c := range columns {
+			s[table] = append(s[table], c)
+		}
+		sort.Strings(s[table])
+	}
+	return s
+}
+
+func openAndClose(file string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	ls, err := NewLStore(ctx, &LocalConfig{File: file})
+	if err != nil {
+		cancel()
+		return err
+	}
+	cancel()
+	ls.Wait()
+	return nil
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	legacy, cleanup := tempDB(t, legacyStmts...)
+	defer cleanup()
+	fresh, cleanup := tempDB(t)
+	defer cleanup()
+	for _, file := range []string{legacy, fresh} {
+		if err := openAndClose(file); err != nil {
+			t.Fatal(err)
+		}
+		version, err := dbVersion(file)
+		if err != nil || version != latestVersion() {
+			t.Errorf("%s: version %d, %v", file, version, err)
+		}
+	}
+	if got, expected := schema(t, legacy), schema(t, fresh); !reflect.DeepEqual(got, expected) {
+		t.Errorf("migrated schema differs:\n%v\n%v", got, expected)
+	}
+
+	// the field data survived and is usable
+	ctx, cancel := context.WithCancel(context.Background())
+	ls, err := NewLStore(ctx, &LocalConfig{File: legacy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		ls.Wait()
+	}()
+	var n int
+	ls.db.QueryRow("SELECT count(*) FROM datapoints WHERE node_id = 1").Scan(&n)
+	if n != 1 {
+		t.Errorf("%d datapoints left", n)
+	}
+	alice, err := ls.Human(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alice.Mappings) != 2 || alice.Mappings[0].Provenance != "legacy" {
+		t.Errorf("got %v", alice)
+	}
+	err = ls.store(&wifi.Device{
+		MAC:         "00:11:22:33:44:55",
+		Randomized:  true,
+		DataPoints:  []*wifi.DataPoint{&wifi.DataPoint{TimeStamp: 2, TunedChannel: 6}},
+		Fingerprint: &wifi.Fingerprint{SSIDs: []string{"legacy"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// created between releases, after the tuned channel was recorded but before
+// the schema had versions
+func TestMigrateTunedChannel(t *testing.T) {
+	stmts := append([]string(nil), legacyStmts...)
+	for _, column := range []string{"sequence", "tuned_frequency", "tuned_channel", "channel_width"} {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE datapoints ADD COLUMN %s INTEGER", column))
+	}
+	between, cleanup := tempDB(t, stmts...)
+	defer cleanup()
+	fresh, cleanup := tempDB(t)
+	defer cleanup()
+	for _, file := range []string{between, fresh} {
+		if err := openAndClose(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, expected := schema(t, between), schema(t, fresh); !reflect.DeepEqual(got, expected) {
+		t.Errorf("migrated schema differs:\n%v\n%v", got, expected)
+	}
+}
+
+func TestMigrateRefusesNewer(t *testing.T) {
+	file, cleanup := tempDB(t, fmt.Sprintf("PRAGMA user_version = %d", latestVersion()+1))
+	defer cleanup()
+	if err := openAndClose(file); err == nil {
+		t.Error("opened a database from the future")
+	}
+}
+
+func TestMigrateRollsBack(t *testing.T) {
+	// not the legacy schema, the first migration fails
+	file, cleanup := tempDB(t, "CREATE TABLE nodes (id INTEGER PRIMARY KEY)", "PRAGMA user_version = 1")
+	defer cleanup()
+	if err := openAndClose(file); err == nil {
+		t.Fatal("migrated a broken database")
+	}
+	version, err := dbVersion(file)
+	if err != nil || version != 1 {
+		t.Errorf("version %d, %v", version, err)
+	}
+	if _, ok := schema(t, file)["fingerprints"]; ok {
+		t.Error("half applied migration")
+	}
+}
+
+func dbVersion(file string) (int, error) {
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return schemaVersion(db)
+}
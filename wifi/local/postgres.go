@@ -0,0 +1,244 @@
+package local
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq" // init the postgres driver
+	"log"
+	"strings"
+)
+
+const PGMaxConnsDefault = 8
+
+// A Store in PostgreSQL, for many collectors feeding one database. With the
+// PostGIS extension available, datapoints get a geography column, so they
+// can be queried by area.
+type PGStore struct {
+	*sqlStore
+}
+
+// Create a new PostgreSQL storage at cnf.DSN, creating or migrating the
+// schema as needed.
+//
+// Note: Should Wait() on the PGStore before exiting the program, to ensure
+// all data is persisted.
+func NewPGStore(ctx context.Context, cnf *LocalConfig) (*PGStore, error) {
+	if cnf.DSN == "" {
+		return nil, fmt.Errorf("postgres needs a DSN")
+	}
+	db, err := sql.Open("postgres", cnf.DSN)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(PGMaxConnsDefault)
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	st, err := newSQLStore(ctx, cnf, db, pgDialect{})
+	if err != nil {
+		return nil, err
+	}
+	return &PGStore{st}, nil
+}
+
+type pgDialect struct{}
+
+// "?" to "$n", "?n" to "$n" and sqlites "INSERT OR IGNORE" to "ON CONFLICT DO
+// NOTHING". Question marks in string literals are left alone.
+func (pgDialect) rebind(query string) string {
+	var b strings.Builder
+	n, quoted := 0, false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			if i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9' {
+				c = '$'
+				break
+			}
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	query = b.String()
+	if strings.HasPrefix(query, "INSERT OR IGNORE") {
+		query = "INSERT" + strings.TrimPrefix(query, "INSERT OR IGNORE") + " ON CONFLICT DO NOTHING"
+	}
+	return query
+}
+
+// pg migrations[i] upgrades from version i+1 to i+2, version 0 is an empty
+// database. Only ever append here.
+var pgMigrations = []func(tx *sql.Tx) error{
+	func(tx *sql.Tx) error {
+		return execAll(tx, pgCreateStmts()...)
+	},
+	addPostGIS,
+	func(tx *sql.Tx) error {
+		return execAll(tx, fmt.Sprintf("CREATE TABLE summaries (id BIGSERIAL PRIMARY KEY, %s)", `
+      node_id BIGINT REFERENCES nodes(id) ON DELETE CASCADE,
+      first_seen BIGINT,
+      last_seen BIGINT,
+      frames BIGINT,
+      min_signal INTEGER,
+      max_signal INTEGER,
+      mean_signal DOUBLE PRECISION,
+      CONSTRAINT unique_summaries UNIQUE (node_id, first_seen)
+    `))
+	},
+	// signal holds an int8 as uint32 (see signalDBm), and queries by MAC are
+	// mostly within a time range
+	func(tx *sql.Tx) error {
+		return execAll(tx,
+			"ALTER TABLE datapoints ALTER COLUMN signal TYPE BIGINT",
+			"DROP INDEX IF EXISTS datapoints_node_id",
+			"CREATE INDEX datapoints_node_id ON datapoints(node_id, time)",
+		)
+	},
+}
+
+func (pgDialect) migrate(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	var version int
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > len(pgMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this binary (%d), refusing to touch it",
+			version, len(pgMigrations))
+	}
+	for ; version < len(pgMigrations); version++ {
+		log.Printf("migrating database schema to version %d", version+1)
+		err = pgInTx(db, version+1, pgMigrations[version])
+		if err != nil {
+			return fmt.Errorf("migration to version %d failed: %v", version+1, err)
+		}
+	}
+	return nil
+}
+
+// run <f> and record <version>, all or nothing
+func pgInTx(db *sql.DB, version int, f func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// concurrent collectors starting up must not migrate twice
+	_, err = tx.Exec("LOCK TABLE schema_version IN EXCLUSIVE MODE")
+	if err != nil {
+		return err
+	}
+	var current int
+	err = tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&current)
+	if err != nil || current >= version {
+		return err
+	}
+	if err = f(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO schema_version(version) VALUES($1)", version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// the same tables as the sqlite schema, see createStmts
+func pgCreateStmts() []string {
+	creat := "CREATE TABLE %s (id BIGSERIAL PRIMARY KEY, %s)"
+
+	return []string{
+		fmt.Sprintf(creat, "nodes", `
+      addr TEXT UNIQUE,
+      randomized BOOLEAN DEFAULT false,
+      pseudo_id TEXT
+    `),
+		"CREATE INDEX nodes_pseudo_id ON nodes(pseudo_id)",
+		fmt.Sprintf(creat, "humans", `
+      name TEXT UNIQUE
+    `),
+		fmt.Sprintf(creat, "human_devices", `
+      human_id BIGINT REFERENCES humans(id) ON DELETE CASCADE,
+      node_id BIGINT REFERENCES nodes(id) ON DELETE CASCADE,
+      probability DOUBLE PRECISION,
+      provenance TEXT,
+      updated BIGINT,
+      CONSTRAINT unique_hds UNIQUE (human_id, node_id)
+    `),
+		"CREATE INDEX human_devices_node_id ON human_devices(node_id)",
+		fmt.Sprintf(creat, "datapoints", `
+      -- unix time in nanoseconds
+      time BIGINT,
+      frequency INTEGER,
+      signal INTEGER,
+      longitude DOUBLE PRECISION,
+      latitude DOUBLE PRECISION,
+      node_id BIGINT REFERENCES nodes(id) ON DELETE CASCADE,
+      sequence INTEGER,
+      tuned_frequency INTEGER,
+      tuned_channel INTEGER,
+      channel_width INTEGER,
+      altitude DOUBLE PRECISION,
+      accuracy DOUBLE PRECISION,
+      fix_age BIGINT,
+      CONSTRAINT unique_dps UNIQUE (time, node_id, signal)
+    `),
+		"CREATE INDEX datapoints_node_id ON datapoints(node_id)",
+		fmt.Sprintf(creat, "fingerprints", `
+      digest TEXT,
+      rates BYTEA,
+      ht_capabs BYTEA,
+      vht_capabs BYTEA,
+      he_capabs BYTEA,
+      ext_capabs BYTEA,
+      vendor_ouis TEXT,
+      ie_ids BYTEA,
+      node_id BIGINT REFERENCES nodes(id) ON DELETE CASCADE,
+      CONSTRAINT unique_fps UNIQUE (digest, node_id)
+    `),
+		fmt.Sprintf(creat, "probed_ssids", `
+      ssid TEXT,
+      node_id BIGINT REFERENCES nodes(id) ON DELETE CASCADE,
+      CONSTRAINT unique_ssids UNIQUE (ssid, node_id)
+    `),
+	}
+}
+
+// a geography column derived from longitude/latitude, if PostGIS can be
+// had. Without it everything else works, so this is not an error.
+func addPostGIS(tx *sql.Tx) error {
+	var available bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'postgis')").Scan(&available)
+	if err != nil {
+		return err
+	}
+	if !available {
+		log.Print("PostGIS is not available, datapoints can't be queried by area efficiently")
+		return nil
+	}
+	// needs privileges we may not have
+	err = execAll(tx, "SAVEPOINT postgis", "CREATE EXTENSION IF NOT EXISTS postgis")
+	if err != nil {
+		log.Printf("PostGIS can't be enabled, datapoints can't be queried by area efficiently: %v", err)
+		_, err = tx.Exec("ROLLBACK TO SAVEPOINT postgis")
+		return err
+	}
+	return execAll(tx,
+		`ALTER TABLE datapoints ADD COLUMN IF NOT EXISTS geom geography(Point, 4326)
+      GENERATED ALWAYS AS (
+        CASE WHEN longitude = 0 AND latitude = 0 THEN NULL
+        ELSE ST_SetSRID(ST_MakePoint(longitude, latitude), 4326)::geography END
+      ) STORED`,
+		"CREATE INDEX IF NOT EXISTS datapoints_geom ON datapoints USING GIST (geom)")
+}
@@ -0,0 +1,170 @@
+package local
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/tinygoprogs/sigint/wifi"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPGRebind(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"SELECT id FROM nodes WHERE addr = ?", "SELECT id FROM nodes WHERE addr = $1"},
+		{"UPDATE nodes SET pseudo_id = ? WHERE id = ?", "UPDATE nodes SET pseudo_id = $1 WHERE id = $2"},
+		{"WHERE (?1 = '' OR h.name = ?1) AND ?2 = 'why?'", "WHERE ($1 = '' OR h.name = $1) AND $2 = 'why?'"},
+		{"INSERT OR IGNORE INTO humans(name) VALUES(?)", "INSERT INTO humans(name) VALUES($1) ON CONFLICT DO NOTHING"},
+	} {
+		if got := (pgDialect{}).rebind(tc.in); got != tc.want {
+			t.Errorf("rebind(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// needs a server, e.g. SIGINT_TEST_POSTGRES="postgres://localhost/sigint_test?sslmode=disable".
+// Every test gets a schema of its own, dropped by cleanup()
+func testDSN(t *testing.T) (dsn string, cleanup func()) {
+	dsn = os.Getenv("SIGINT_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("SIGINT_TEST_POSTGRES not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("sigint_test_%d", time.Now().UnixNano())
+	if _, err = db.Exec("CREATE SCHEMA " + schema); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	// unknown parameters are passed on to the server by lib/pq
+	if strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "search_path=" + schema
+	} else {
+		dsn += " search_path=" + schema
+	}
+	return dsn, func() {
+		if _, err := db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("dropping %s: %v", schema, err)
+		}
+		db.Close()
+	}
+}
+
+func TestPGStore(t *testing.T) {
+	dsn, cleanup := testDSN(t)
+	defer cleanup()
+	cnf := &LocalConfig{Driver: "postgres", DSN: dsn}
+	ctx, cancel := context.WithCancel(context.Background())
+	st, err := NewStore(ctx, cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg := st.(*PGStore)
+	defer func() {
+		cancel()
+		pg.Wait()
+	}()
+	dev := testDevice("02:00:00:00:00:01", "pseudo", 1000, 3)
+	// dBm as the capture stores them, far beyond INTEGER
+	dbm := int8(-60)
+	for _, dp := range dev.DataPoints {
+		dp.Signal = uint32(dbm)
+	}
+	dev.Fingerprint = &wifi.Fingerprint{Rates: []byte{2, 4}, VendorOUIs: []uint32{0x0050f2}, SSIDs: []string{"home"}}
+	// the empty device fails the batch, the others are stored one by one
+	pg.storeBatch([]*wifi.Device{dev, dev, {}})
+	stats := pg.Stats()
+	if stats.Devices != 2 || stats.DataPoints != 6 || stats.Failed != 1 {
+		t.Errorf("got %+v", stats)
+	}
+	var dps int
+	pg.db.QueryRow("SELECT count(*) FROM datapoints").Scan(&dps)
+	if dps != 3 {
+		t.Errorf("%d datapoints", dps)
+	}
+	res, err := pg.Query(ctx, &wifi.SightingQuery{MAC: dev.MAC, MinSignal: -70})
+	if err != nil || len(res.Sightings) != 3 || int8(res.Sightings[0].DataPoint.Signal) != dbm {
+		t.Errorf("got %v, %v", res, err)
+	}
+	fp, err := pg.GetFingerprint(ctx, dev)
+	if err != nil || len(fp.SSIDs) != 1 || len(fp.VendorOUIs) != 1 {
+		t.Errorf("got %v, %v", fp, err)
+	}
+
+	_, err = pg.NewMapping(ctx, &wifi.HumanMapping{
+		ViaName:    map[string]*wifi.Devices{"alice": {Devices: []*wifi.Device{dev}}},
+		Provenance: "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	humans, err := pg.GetHumans(ctx, &wifi.HumanQuery{MAC: dev.MAC})
+	if err != nil || len(humans.Humans) != 1 || humans.Humans[0].Name != "alice" {
+		t.Errorf("got %v, %v", humans, err)
+	}
+}
+
+// databases created by the first schema, whose signal column was too narrow
+func TestPGStoreWidensSignal(t *testing.T) {
+	dsn, cleanup := testDSN(t)
+	defer cleanup()
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("CREATE TABLE schema_version (version INTEGER NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	if err = pgInTx(db, 1, pgMigrations[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pg, err := NewPGStore(ctx, &LocalConfig{DSN: dsn})
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		pg.Wait()
+	}()
+	dev := testDevice("02:00:00:00:00:01", "", 1000, 1)
+	dev.DataPoints[0].Signal = dBm(-60)
+	if err = pg.store(dev); err != nil {
+		t.Fatal(err)
+	}
+	var index string
+	pg.db.QueryRow(`SELECT indexdef FROM pg_indexes
+      WHERE schemaname = current_schema() AND indexname = 'datapoints_node_id'`).Scan(&index)
+	if !strings.Contains(index, "time") {
+		t.Errorf("index: %s", index)
+	}
+}
+
+func TestPGStoreRefusesNewer(t *testing.T) {
+	dsn, cleanup := testDSN(t)
+	defer cleanup()
+	cnf := &LocalConfig{DSN: dsn}
+	ctx, cancel := context.WithCancel(context.Background())
+	pg, err := NewPGStore(ctx, cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := len(pgMigrations) + 1
+	pg.db.Exec("INSERT INTO schema_version(version) VALUES($1)", newer)
+	if err = (pgDialect{}).migrate(pg.db); err == nil {
+		t.Error("migrated a newer schema")
+	}
+	cancel()
+	pg.Wait()
+}
@@ -0,0 +1,137 @@
+package local
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/tinygoprogs/sigint/wifi"
+	"strings"
+)
+
+const QueryLimitDefault = 1000
+const QueryLimitMax = 10000
+
+// the signal column holds int8 dBm values cast to uint32, see wifi.DataPoint
+const signalDBm = "(CASE WHEN dp.signal >= 2147483648 THEN dp.signal - 4294967296 ELSE dp.signal END)"
+
+// Sightings matching <q>, ordered by time. Pages are cut by the (time, id)
+// of the last sighting, so sightings stored meanwhile don't shift them.
+func (st *sqlStore) Query(ctx context.Context, q *wifi.SightingQuery) (*wifi.Sightings, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	cond := func(c string, a ...interface{}) {
+		where = append(where, c)
+		args = append(args, a...)
+	}
+	if q.GetMAC() != "" {
+		cond("n.addr = ?", q.MAC)
+	}
+	if q.GetFrom() != 0 {
+		cond("dp.time >= ?", q.From)
+	}
+	if q.GetTo() != 0 {
+		cond("dp.time < ?", q.To)
+	}
+	if q.GetFrequency() != 0 {
+		cond("dp.frequency = ?", q.Frequency)
+	}
+	if q.GetMinSignal() != 0 {
+		cond(signalDBm+" >= ?", q.MinSignal)
+	}
+	if a := q.GetArea(); a != nil {
+		if a.MinLon > a.MaxLon || a.MinLat > a.MaxLat {
+			return nil, fmt.Errorf("empty area %v", a)
+		}
+		cond("dp.longitude BETWEEN ? AND ? AND dp.latitude BETWEEN ? AND ?",
+			a.MinLon, a.MaxLon, a.MinLat, a.MaxLat)
+		// no location, see wireless.go
+		cond("NOT (dp.longitude = 0 AND dp.latitude = 0)")
+	}
+	if q.GetPageToken() != "" {
+		var stamp, id int64
+		_, err := fmt.Sscanf(q.PageToken, "%d.%d", &stamp, &id)
+		if err != nil {
+			return nil, fmt.Errorf("bad page token '%s'", q.PageToken)
+		}
+		cond("(dp.time > ? OR (dp.time = ? AND dp.id > ?))", stamp, stamp, id)
+	}
+	limit := int(q.GetLimit())
+	if limit == 0 {
+		limit = QueryLimitDefault
+	}
+	if limit > QueryLimitMax {
+		limit = QueryLimitMax
+	}
+
+	query := `SELECT dp.id, n.addr, dp.time, COALESCE(dp.frequency, 0), COALESCE(dp.signal, 0),
+      COALESCE(dp.longitude, 0), COALESCE(dp.latitude, 0), COALESCE(dp.altitude, 0),
+      COALESCE(dp.accuracy, 0), COALESCE(dp.fix_age, 0), COALESCE(dp.sequence, 0),
+      COALESCE(dp.tuned_frequency, 0), COALESCE(dp.tuned_channel, 0), COALESCE(dp.channel_width, 0),
+      sm.frames, sm.first_seen, sm.min_signal, sm.max_signal, sm.mean_signal
+    FROM datapoints dp JOIN nodes n ON n.id = dp.node_id
+      -- aggregated datapoints are the last frame of their summary
+      LEFT JOIN summaries sm ON sm.node_id = dp.node_id AND sm.last_seen = dp.time`
+	if len(where) != 0 {
+		query += "\n    WHERE " + strings.Join(where, " AND ")
+	}
+	// one more, to know if there is a next page
+	query += "\n    ORDER BY dp.time, dp.id LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := st.db.QueryContext(ctx, st.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := &wifi.Sightings{}
+	var id, stamp int64
+	for rows.Next() {
+		if len(res.Sightings) == limit {
+			res.NextPageToken = fmt.Sprintf("%d.%d", stamp, id)
+			break
+		}
+		s, err := scanSighting(rows, &id, &stamp)
+		if err != nil {
+			return nil, err
+		}
+		res.Sightings = append(res.Sightings, s)
+	}
+	return res, rows.Err()
+}
+
+// <id> and <stamp> are set to the datapoints id and time
+func scanSighting(rows *sql.Rows, id, stamp *int64) (*wifi.Sighting, error) {
+	var (
+		s                                          wifi.Sighting
+		dp                                         wifi.DataPoint
+		loc                                        wifi.Coordinates
+		lon, lat                                   float64
+		freq, signal, fix_age, seq, tfreq, tch, cw int64
+		frames, first_seen, min_signal, max_signal sql.NullInt64
+		mean_signal                                sql.NullFloat64
+	)
+	err := rows.Scan(id, &s.MAC, stamp, &freq, &signal, &lon, &lat, &loc.Alt, &loc.Acc,
+		&fix_age, &seq, &tfreq, &tch, &cw,
+		&frames, &first_seen, &min_signal, &max_signal, &mean_signal)
+	if err != nil {
+		return nil, err
+	}
+	if frames.Valid {
+		dp.Summary = &wifi.Summary{
+			Frames:     uint32(frames.Int64),
+			FirstSeen:  uint64(first_seen.Int64),
+			LastSeen:   uint64(*stamp),
+			MinSignal:  int32(min_signal.Int64),
+			MaxSignal:  int32(max_signal.Int64),
+			MeanSignal: float32(mean_signal.Float64),
+		}
+	}
+	loc.Lon, loc.Lat, loc.FixAge = float32(lon), float32(lat), uint64(fix_age)
+	dp.TimeStamp, dp.Frequency, dp.Signal = uint64(*stamp), uint32(freq), uint32(signal)
+	dp.Sequence, dp.TunedFrequency, dp.TunedChannel, dp.ChannelWidth = uint32(seq), uint32(tfreq), uint32(tch), uint32(cw)
+	dp.Location = &loc
+	s.DataPoint = &dp
+	return &s, nil
+}
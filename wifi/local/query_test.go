@@ -0,0 +1,189 @@
+package local
+
+import (
+	"context"
+	"github.com/tinygoprogs/sigint/wifi"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func dBm(s int8) uint32 {
+	return uint32(s)
+}
+
+func TestLStoreQuery(t *testing.T) {
+	ctx, ls, stop := testLStore(t, LocalConfig{})
+	defer stop()
+
+	phone, watch := "02:00:00:00:00:01", "02:00:00:00:00:02"
+	dp := func(stamp uint64, freq uint32, signal int8, lon, lat float32) *wifi.DataPoint {
+		return &wifi.DataPoint{TimeStamp: stamp, Frequency: freq, Signal: dBm(signal),
+			Location: &wifi.Coordinates{Lon: lon, Lat: lat, Acc: 5}}
+	}
+	devs := []*wifi.Device{
+		{MAC: phone, DataPoints: []*wifi.DataPoint{
+			dp(100, 2412, -40, 11.5, 48.1),
+			dp(200, 2437, -80, 11.6, 48.2),
+			dp(300, 5180, -60, 0, 0),
+		}},
+		{MAC: watch, DataPoints: []*wifi.DataPoint{
+			dp(200, 2412, -50, 13.4, 52.5),
+			dp(400, 2412, -90, 11.55, 48.15),
+		}},
+	}
+	for _, dev := range devs {
+		if err := ls.store(dev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name  string
+		q     *wifi.SightingQuery
+		stamp []uint64
+	}{
+		{"all", &wifi.SightingQuery{}, []uint64{100, 200, 200, 300, 400}},
+		{"mac", &wifi.SightingQuery{MAC: watch}, []uint64{200, 400}},
+		{"time", &wifi.SightingQuery{From: 200, To: 400}, []uint64{200, 200, 300}},
+		{"frequency", &wifi.SightingQuery{Frequency: 2412}, []uint64{100, 200, 400}},
+		{"signal", &wifi.SightingQuery{MinSignal: -60}, []uint64{100, 200, 300}},
+		{"area", &wifi.SightingQuery{Area: &wifi.BoundingBox{MinLon: 11, MinLat: 48, MaxLon: 12, MaxLat: 49}},
+			[]uint64{100, 200, 400}},
+		{"no location", &wifi.SightingQuery{Area: &wifi.BoundingBox{MinLon: -1, MinLat: -1, MaxLon: 1, MaxLat: 1}}, nil},
+		{"combined", &wifi.SightingQuery{MAC: phone, Frequency: 2412, MinSignal: -45}, []uint64{100}},
+	} {
+		res, err := ls.Query(ctx, tc.q)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var got []uint64
+		for _, s := range res.Sightings {
+			got = append(got, s.DataPoint.TimeStamp)
+		}
+		if len(got) != len(tc.stamp) || res.NextPageToken != "" {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.stamp)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.stamp[i] {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.stamp)
+				break
+			}
+		}
+	}
+
+	res, err := ls.Query(ctx, &wifi.SightingQuery{MAC: phone, From: 100, To: 101})
+	if err != nil || len(res.Sightings) != 1 {
+		t.Fatalf("got %v, %v", res, err)
+	}
+	s := res.Sightings[0]
+	if s.MAC != phone || int8(s.DataPoint.Signal) != -40 || s.DataPoint.Frequency != 2412 ||
+		s.DataPoint.Location.Lon != 11.5 || s.DataPoint.Location.Acc != 5 {
+		t.Errorf("got %v", s)
+	}
+
+	// pages of two, until there is no next page
+	q := &wifi.SightingQuery{Limit: 2}
+	var pages, n int
+	for {
+		res, err := ls.Query(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		n += len(res.Sightings)
+		if res.NextPageToken == "" {
+			break
+		}
+		q.PageToken = res.NextPageToken
+	}
+	if pages != 3 || n != 5 {
+		t.Errorf("%d sightings on %d pages", n, pages)
+	}
+
+	if _, err := ls.Query(ctx, &wifi.SightingQuery{PageToken: "garbage"}); err == nil {
+		t.Error("accepted a bad page token")
+	}
+}
+
+func TestLStoreSummaries(t *testing.T) {
+	ctx, ls, stop := testLStore(t, LocalConfig{})
+	defer stop()
+
+	sum := &wifi.Summary{Frames: 3, MinSignal: -80, MaxSignal: -40, MeanSignal: -60, FirstSeen: 100, LastSeen: 300}
+	dev := &wifi.Device{MAC: "02:00:00:00:00:01", DataPoints: []*wifi.DataPoint{
+		{TimeStamp: 50, Signal: dBm(-70)},
+		{TimeStamp: 300, Signal: dBm(-60), Summary: sum},
+	}}
+	// resent batches are ignored
+	for i := 0; i < 2; i++ {
+		if err := ls.store(dev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var n int
+	if err := ls.db.QueryRow("SELECT count(*) FROM summaries").Scan(&n); err != nil || n != 1 {
+		t.Errorf("%d summaries, %v", n, err)
+	}
+	res, err := ls.Query(ctx, &wifi.SightingQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Sightings) != 2 {
+		t.Fatalf("got %v", res)
+	}
+	if got := res.Sightings[0].DataPoint.Summary; got != nil {
+		t.Errorf("single frame with a summary: %v", got)
+	}
+	if got := res.Sightings[1].DataPoint.Summary; got.String() != sum.String() {
+		t.Errorf("got %v, want %v", got, sum)
+	}
+}
+
+func TestLStoreReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readonly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "readonly.db")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err = NewLStore(ctx, &LocalConfig{File: file, ReadOnly: true}); err == nil {
+		t.Fatal("opened a missing database")
+	}
+	if _, err = os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("created %s: %v", file, err)
+	}
+
+	ls, err := NewLStore(ctx, &LocalConfig{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ls.store(testDevice("02:00:00:00:00:01", "", 100, 2)); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	ls.Wait()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	ro, err := NewLStore(ctx, &LocalConfig{File: file, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		ro.Wait()
+	}()
+	res, err := ro.Query(ctx, &wifi.SightingQuery{})
+	if err != nil || len(res.Sightings) != 2 {
+		t.Errorf("got %v, %v", res, err)
+	}
+	devs := &wifi.Devices{Devices: []*wifi.Device{testDevice("02:00:00:00:00:02", "", 200, 1)}}
+	if _, err = ro.NewDevices(ctx, devs); err == nil {
+		t.Error("stored into a read-only store")
+	}
+}
@@ -0,0 +1,42 @@
+package local
+
+import (
+	"context"
+	"github.com/tinygoprogs/sigint/wifi"
+	"google.golang.org/grpc"
+	"log"
+	"net"
+)
+
+// Serve the Collector service on <lis>, backed by a Store (see NewStore), until ctx is
+// Done(). Running RPCs are finished and everything is persisted before
+// returning.
+func Serve(ctx context.Context, lis net.Listener, cnf *LocalConfig, opts ...grpc.ServerOption) error {
+	// outlives ctx, until the last RPC is done
+	store_ctx, stop_store := context.WithCancel(context.Background())
+	ls, err := NewStore(store_ctx, cnf)
+	if err != nil {
+		stop_store()
+		return err
+	}
+	srv := grpc.NewServer(opts...)
+	wifi.RegisterCollectorServer(srv, ls)
+	serveMetrics(ctx, cnf.Metrics, ls)
+
+	served := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Print("stopping grpc server")
+			srv.GracefulStop()
+		case <-served:
+		}
+	}()
+	log.Printf("serving Collector on %v", lis.Addr())
+	err = srv.Serve(lis)
+	close(served)
+
+	stop_store()
+	ls.Wait()
+	return err
+}
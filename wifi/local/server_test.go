@@ -0,0 +1,76 @@
+package local
+
+import (
+	"context"
+	"github.com/tinygoprogs/sigint/wifi"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cnf := &LocalConfig{File: filepath.Join(dir, "collector.db")}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- Serve(ctx, lis, cnf)
+	}()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := wifi.NewCollectorClient(conn)
+	dev := &wifi.Device{
+		MAC: "12:34:56:78:9a:bc",
+		DataPoints: []*wifi.DataPoint{
+			&wifi.DataPoint{TimeStamp: uint64(time.Now().UnixNano()), Location: &wifi.Coordinates{}},
+		},
+		Fingerprint: &wifi.Fingerprint{SSIDs: []string{"remote"}},
+	}
+	ack, err := client.NewDevices(ctx, &wifi.Devices{Devices: []*wifi.Device{dev}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.NDevices != 1 || ack.NDataPoints != 1 {
+		t.Errorf("got ack %v", ack)
+	}
+
+	cancel()
+	if err = <-served; err != nil {
+		t.Fatal(err)
+	}
+
+	// shutdown must have persisted everything
+	ctx, cancel = context.WithCancel(context.Background())
+	ls, err := NewLStore(ctx, cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cancel()
+		ls.Wait()
+	}()
+	fp, err := ls.GetFingerprint(ctx, &wifi.Device{MAC: dev.MAC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fp.SSIDs) != 1 || fp.SSIDs[0] != "remote" {
+		t.Errorf("got %v", fp)
+	}
+}
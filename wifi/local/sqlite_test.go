@@ -2,10 +2,14 @@ package local
 
 import (
 	"context"
+	"database/sql"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"github.com/tinygoprogs/sigint/wifi"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -22,7 +26,7 @@ func init() {
 
 func TestLStoreStoreDeviceAndQueryIt(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
-	ls, err := NewLStore(ctx, &LocalConfig{File: *dbname, Nmaps: 10, ChanSize: 2})
+	ls, err := NewLStore(ctx, &LocalConfig{File: *dbname, ChanSize: 2})
 	if err != nil {
 		t.Errorf("db creation failed: %v", err)
 	}
@@ -44,3 +48,214 @@ func TestLStoreStoreDeviceAndQueryIt(t *testing.T) {
 	// TODO: check if device is persisted
 	//os.Remove(*dbname)
 }
+
+func TestLStoreFingerprint(t *testing.T) {
+	ctx, ls, stop := testLStore(t, LocalConfig{})
+	defer stop()
+
+	fp := &wifi.Fingerprint{
+		SSIDs:      []string{"home", "work"},
+		Rates:      []byte{0x82, 0x84},
+		VendorOUIs: []uint32{0x0050f208, 0x00101802},
+		IEIDs:      []byte{0, 1, 221, 221},
+	}
+	dev := &wifi.Device{
+		MAC: "66:55:44:33:22:11",
+		DataPoints: []*wifi.DataPoint{
+			&wifi.DataPoint{TimeStamp: uint64(time.Now().UnixNano()), Location: &wifi.Coordinates{}},
+		},
+		Fingerprint: fp,
+	}
+	for i := 0; i < 2; i++ { // storing twice must not duplicate anything
+		if err := ls.store(dev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ls.GetFingerprint(ctx, &wifi.Device{MAC: dev.MAC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != fp.String() {
+		t.Errorf("got %v, expected %v", got, fp)
+	}
+}
+
+func TestLStoreMapping(t *testing.T) {
+	ctx, ls, stop := testLStore(t, LocalConfig{})
+	defer stop()
+
+	devices := func(macs ...string) *wifi.Devices {
+		devs := &wifi.Devices{}
+		for _, mac := range macs {
+			devs.Devices = append(devs.Devices, &wifi.Device{MAC: mac})
+		}
+		return devs
+	}
+	phone, watch := "02:00:00:00:00:01", "02:00:00:00:00:02"
+	for _, m := range []*wifi.HumanMapping{
+		{ViaName: map[string]*wifi.Devices{"alice": devices(phone, watch)}, Probability: 0.9, Provenance: "analyst"},
+		{ViaName: map[string]*wifi.Devices{"bob": devices(phone)}, Probability: 0.2},
+		// replaces the first mapping of the watch
+		{ViaName: map[string]*wifi.Devices{"alice": devices(watch)}, Probability: 0.5, Provenance: "linker"},
+	} {
+		if _, err := ls.NewMapping(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := ls.NewMapping(ctx, &wifi.HumanMapping{ViaName: map[string]*wifi.Devices{"carol": devices("")}})
+	if err == nil {
+		t.Error("mapped a device without MAC")
+	}
+
+	alice, err := ls.Human(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []wifi.HumanDevice{
+		{MAC: phone, Probability: 0.9, Provenance: "analyst"},
+		{MAC: watch, Probability: 0.5, Provenance: "linker"},
+	}
+	if len(alice.Mappings) != len(expected) {
+		t.Fatalf("got %v", alice)
+	}
+	for i, e := range expected {
+		if got := alice.Mappings[i]; got.MAC != e.MAC || got.Probability != e.Probability ||
+			got.Provenance != e.Provenance {
+			t.Errorf("device %d: got %v, expected %v", i, got, e)
+		}
+	}
+
+	humans, err := ls.GetHumans(ctx, &wifi.HumanQuery{MAC: phone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(humans.Humans) != 2 || humans.Humans[0].Name != "alice" || humans.Humans[1].Name != "bob" ||
+		humans.Humans[1].Mappings[0].Probability != 0.2 {
+		t.Errorf("owners of the phone: %v", humans)
+	}
+	// the failed mapping was rolled back
+	if _, err = ls.Human(ctx, "carol"); err == nil {
+		t.Error("carol exists")
+	}
+}
+
+// a store in a fresh temporary directory. stop() cancels it, waits for the
+// writes and removes the directory
+func testLStore(tb testing.TB, cnf LocalConfig) (ctx context.Context, ls *LStore, stop func()) {
+	dir, err := ioutil.TempDir("", "lstore")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cnf.File = filepath.Join(dir, "test.db")
+	ctx, cancel := context.WithCancel(context.Background())
+	ls, err = NewLStore(ctx, &cnf)
+	if err != nil {
+		cancel()
+		os.RemoveAll(dir)
+		tb.Fatalf("db creation failed: %v", err)
+	}
+	return ctx, ls, func() {
+		cancel()
+		ls.Wait()
+		os.RemoveAll(dir)
+	}
+}
+
+func testDevice(mac, pseudo string, stamp uint64, ndps int) *wifi.Device {
+	dev := &wifi.Device{MAC: mac, PseudoID: pseudo}
+	for i := 0; i < ndps; i++ {
+		dev.DataPoints = append(dev.DataPoints, &wifi.DataPoint{
+			TimeStamp: stamp + uint64(i), Signal: 200, Location: &wifi.Coordinates{},
+		})
+	}
+	return dev
+}
+
+func TestLStoreBatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	cnf := &LocalConfig{File: filepath.Join(dir, "batches.db"), BatchSize: 64, NodeCacheSize: 16}
+	ls, err := NewLStore(ctx, cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		mac := fmt.Sprintf("02:00:00:00:00:%02x", i/2%32)
+		pseudo := ""
+		if i >= 500 {
+			pseudo = "rnd-" + mac
+		}
+		// every device is sent twice, duplicates must be ignored
+		ls.push <- testDevice(mac, pseudo, uint64(i/2*2), 2)
+	}
+	cancel()
+	ls.Wait()
+
+	stats := ls.Stats()
+	if stats.Devices != 1000 || stats.DataPoints != 2000 || stats.Failed != 0 {
+		t.Errorf("got %+v", stats)
+	}
+	if stats.Batches >= 1000 {
+		t.Errorf("no batching: %d transactions", stats.Batches)
+	}
+
+	db, err := sql.Open("sqlite3", cnf.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var nodes, linked, dps int
+	db.QueryRow("SELECT count(*), count(pseudo_id) FROM nodes").Scan(&nodes, &linked)
+	db.QueryRow("SELECT count(*) FROM datapoints").Scan(&dps)
+	if nodes != 32 || linked != 32 || dps != 1000 {
+		t.Errorf("%d nodes, %d linked, %d datapoints", nodes, linked, dps)
+	}
+}
+
+func TestLStoreBatchFallback(t *testing.T) {
+	_, ls, stop := testLStore(t, LocalConfig{})
+	defer stop()
+	// the empty device fails the batch, the others are stored one by one
+	ls.storeBatch([]*wifi.Device{
+		testDevice("02:00:00:00:00:01", "", 1000, 2), {}, testDevice("02:00:00:00:00:02", "", 1000, 3)})
+	stats := ls.Stats()
+	if stats.Devices != 2 || stats.DataPoints != 5 || stats.Failed != 1 {
+		t.Errorf("got %+v", stats)
+	}
+	var dps int
+	ls.db.QueryRow("SELECT count(*) FROM datapoints").Scan(&dps)
+	if dps != 5 {
+		t.Errorf("%d datapoints", dps)
+	}
+	// one transaction per stored device
+	for _, s := range ls.Metrics() {
+		if s.Name == "sigint_store_batch_seconds_count" && s.Value != 2 ||
+			s.Name == "sigint_store_failed_total" && s.Value != 1 {
+			t.Errorf("%s = %v", s.Name, s.Value)
+		}
+	}
+}
+
+func BenchmarkLStoreBatch(b *testing.B) {
+	_, ls, stop := testLStore(b, LocalConfig{})
+	defer stop()
+	var batch []*wifi.Device
+	for i := 0; i < 1024; i++ {
+		batch = append(batch, testDevice(fmt.Sprintf("02:00:00:00:%02x:%02x", i/256, i%256), "", 0, 10))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, dev := range batch {
+			for _, dp := range dev.DataPoints {
+				dp.TimeStamp += 10
+			}
+		}
+		ls.storeBatch(batch)
+	}
+	b.ReportMetric(float64(b.N*len(batch)*10)/b.Elapsed().Seconds(), "datapoints/s")
+}
@@ -0,0 +1,90 @@
+package local
+
+import (
+	"github.com/tinygoprogs/sigint/wifi/metrics"
+	"log"
+	"sync"
+	"time"
+)
+
+// upper bounds of the store latency histogram, in seconds
+var LatencyBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5}
+
+// write statistics of a Store, since it was created
+type StoreStats struct {
+	Devices    uint64
+	DataPoints uint64
+	// transactions
+	Batches uint64
+	// devices that could not be stored
+	Failed uint64
+	// time spent storing batches
+	Busy time.Duration
+	// devices waiting to be stored
+	Queued int
+}
+
+type storeStats struct {
+	mtx sync.Mutex
+	StoreStats
+	// at the last log()
+	last     StoreStats
+	last_log time.Time
+	// of batches, in seconds
+	latency *metrics.Histogram
+}
+
+func (s *storeStats) stored(ndevs, ndps int, took time.Duration) {
+	s.mtx.Lock()
+	s.Devices += uint64(ndevs)
+	s.DataPoints += uint64(ndps)
+	s.Batches++
+	s.Busy += took
+	s.mtx.Unlock()
+	s.latency.Observe(took.Seconds())
+}
+
+func (s *storeStats) failed(ndevs int) {
+	s.mtx.Lock()
+	s.Failed += uint64(ndevs)
+	s.mtx.Unlock()
+}
+
+func (s *storeStats) snapshot() StoreStats {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.StoreStats
+}
+
+// throughput since the last call, and the queue depth
+func (s *storeStats) log(queued, capacity int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(s.last_log).Seconds()
+	ndevs, ndps := s.Devices-s.last.Devices, s.DataPoints-s.last.DataPoints
+	log.Printf("StoreStats[devices:%d(%.0f/s) datapoints:%d(%.0f/s) batches:%d failed:%d busy:%v queue:%d/%d]",
+		s.Devices, float64(ndevs)/elapsed, s.DataPoints, float64(ndps)/elapsed,
+		s.Batches, s.Failed, s.Busy-s.last.Busy, queued, capacity)
+	s.last, s.last_log = s.StoreStats, now
+}
+
+// a snapshot
+func (st *sqlStore) Stats() StoreStats {
+	stats := st.stats.snapshot()
+	stats.Queued = len(st.push)
+	return stats
+}
+
+// Stats() and the latency of batches, see metrics.Serve
+func (st *sqlStore) Metrics() []metrics.Sample {
+	stats := st.Stats()
+	return append(st.stats.latency.Samples("sigint_store_batch_seconds", "time to store a batch of devices"),
+		metrics.Counter("sigint_store_devices_total", "devices stored", float64(stats.Devices)),
+		metrics.Counter("sigint_store_datapoints_total", "datapoints stored", float64(stats.DataPoints)),
+		metrics.Counter("sigint_store_batches_total", "transactions", float64(stats.Batches)),
+		metrics.Counter("sigint_store_failed_total", "devices that could not be stored", float64(stats.Failed)),
+		metrics.Counter("sigint_store_busy_seconds_total", "time spent storing batches", stats.Busy.Seconds()),
+		metrics.Gauge("sigint_store_queued", "devices waiting to be stored", float64(stats.Queued)),
+		metrics.Gauge("sigint_store_queue_capacity", "devices that can wait to be stored", float64(cap(st.push))))
+}
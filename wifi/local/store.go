@@ -0,0 +1,552 @@
+package local
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/tinygoprogs/sigint/wifi"
+	"github.com/tinygoprogs/sigint/wifi/metrics"
+	"log"
+	"time"
+)
+
+const DriverDefault = "sqlite3"
+
+// Where collected data ends up, see NewStore.
+type Store interface {
+	// devices are persisted asynchronously, see Wait
+	wifi.CollectorServer
+	// wait for data to be persisted, after the context passed at creation is
+	// Done()
+	Wait()
+	Stats() StoreStats
+	// for metrics.Serve
+	Metrics() []metrics.Sample
+}
+
+// an LStore or a PGStore, depending on cnf.Driver
+func NewStore(ctx context.Context, cnf *LocalConfig) (Store, error) {
+	if cnf.Driver == "" {
+		cnf.Driver = DriverDefault
+	}
+	switch cnf.Driver {
+	case "sqlite3":
+		return NewLStore(ctx, cnf)
+	case "postgres":
+		return NewPGStore(ctx, cnf)
+	}
+	return nil, fmt.Errorf("unknown storage driver '%s'", cnf.Driver)
+}
+
+// what differs between the databases
+type dialect interface {
+	// rewrite a query written for sqlite
+	rebind(query string) string
+	// bring the schema up to date, creates it if needed
+	migrate(db *sql.DB) error
+}
+
+// The storage logic shared by all SQL databases, queries are written for
+// sqlite and passed through the dialect.
+type sqlStore struct {
+	conf       LocalConfig
+	db         *sql.DB
+	dialect    dialect
+	stmts      storeStmts
+	nodes      *nodeCache
+	stats      storeStats
+	push       chan *wifi.Device
+	flush_done chan bool
+}
+
+// takes over <db>, closes it once ctx is Done()
+func newSQLStore(ctx context.Context, cnf *LocalConfig, db *sql.DB, d dialect) (st *sqlStore, err error) {
+	if cnf.ChanSize == 0 {
+		cnf.ChanSize = ChanSizeDefault
+	}
+	if cnf.BatchSize == 0 {
+		cnf.BatchSize = BatchSizeDefault
+	}
+	if cnf.BatchDelay == 0 {
+		cnf.BatchDelay = BatchDelayDefault
+	}
+	if cnf.NodeCacheSize == 0 {
+		cnf.NodeCacheSize = NodeCacheSizeDefault
+	}
+	if cnf.LogStatsEvery == 0 {
+		cnf.LogStatsEvery = LogStatsEveryDefault
+	}
+	st = &sqlStore{
+		conf:       *cnf,
+		db:         db,
+		dialect:    d,
+		nodes:      newNodeCache(cnf.NodeCacheSize),
+		push:       make(chan *wifi.Device, cnf.ChanSize),
+		flush_done: make(chan bool, 1),
+	}
+	st.stats.last_log = time.Now()
+	st.stats.latency = metrics.NewHistogram(LatencyBuckets...)
+	if cnf.ReadOnly {
+		go st.closeWhenDone(ctx)
+		return
+	}
+	// creates new databases, too
+	err = d.migrate(db)
+	if err == nil {
+		err = st.prepare()
+	}
+	if err != nil {
+		st.stmts.close()
+		db.Close()
+		return nil, err
+	}
+	go st.sql_io(ctx)
+	return
+}
+
+func (st *sqlStore) q(query string) string {
+	return st.dialect.rebind(query)
+}
+
+// persist new devices. The Ack counts what was queued for the writer, not
+// what was committed: a crash may lose acked devices, and ones the database
+// rejects are only counted in Stats().Failed.
+func (st *sqlStore) NewDevices(ctx context.Context, devs *wifi.Devices) (*wifi.Ack, error) {
+	var ndevs, ndps int
+	var err error
+	if st.conf.ReadOnly {
+		return &wifi.Ack{}, fmt.Errorf("store is read-only")
+	}
+	for _, dev := range devs.GetDevices() {
+		select {
+		case st.push <- dev:
+		case <-ctx.Done():
+			// remote callers may give up, ack what we got
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+		ndps += len(dev.GetDataPoints())
+		ndevs++
+	}
+	return &wifi.Ack{
+		NDataPoints: int32(ndps),
+		NDevices:    int32(ndevs),
+	}, err
+}
+
+// persist new mapping, existing ones of the same human and device are
+// replaced. Only the MACs of the devices are used.
+func (st *sqlStore) NewMapping(ctx context.Context, devs *wifi.HumanMapping) (*wifi.Ack, error) {
+	var ndevs int
+	probability := devs.GetProbability()
+	if probability == 0 {
+		probability = 1
+	}
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for name, mapped := range devs.GetViaName() {
+		var human_id int64
+		_, err = tx.Exec(st.q("INSERT OR IGNORE INTO humans(name) VALUES(?)"), name)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.QueryRow(st.q("SELECT id FROM humans WHERE name = ?"), name).Scan(&human_id)
+		if err != nil {
+			return nil, err
+		}
+		for _, dev := range mapped.GetDevices() {
+			var node_id int64
+			if dev.GetMAC() == "" {
+				return nil, errors.New("mapping a device without MAC")
+			}
+			_, err = tx.Exec(st.q("INSERT OR IGNORE INTO nodes(addr) VALUES(?)"), dev.MAC)
+			if err != nil {
+				return nil, err
+			}
+			err = tx.QueryRow(st.q("SELECT id FROM nodes WHERE addr = ?"), dev.MAC).Scan(&node_id)
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.Exec(st.q(`INSERT INTO human_devices(human_id, node_id, probability, provenance, updated)
+        VALUES(?, ?, ?, ?, ?)
+        ON CONFLICT(human_id, node_id) DO UPDATE SET
+          probability = excluded.probability,
+          provenance = excluded.provenance,
+          updated = excluded.updated`),
+				human_id, node_id, probability, devs.GetProvenance(), time.Now().UnixNano())
+			if err != nil {
+				return nil, err
+			}
+			ndevs++
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &wifi.Ack{NDevices: int32(ndevs)}, nil
+}
+
+// everyone matching <q>, with all their devices, most probable first
+func (st *sqlStore) GetHumans(ctx context.Context, q *wifi.HumanQuery) (*wifi.Humans, error) {
+	rows, err := st.db.QueryContext(ctx, st.q(`SELECT h.name, n.addr, n.randomized, n.pseudo_id,
+      hd.probability, hd.provenance
+    FROM humans h
+      JOIN human_devices hd ON hd.human_id = h.id
+      JOIN nodes n ON n.id = hd.node_id
+    WHERE (?1 = '' OR h.name = ?1)
+      AND (?2 = '' OR h.id IN (SELECT human_id FROM human_devices
+        JOIN nodes ON nodes.id = human_devices.node_id WHERE nodes.addr = ?2))
+    ORDER BY h.name, hd.probability DESC, n.addr`), q.GetName(), q.GetMAC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	humans := &wifi.Humans{}
+	var human *wifi.Human
+	for rows.Next() {
+		var (
+			name       string
+			randomized sql.NullBool
+			pseudo_id  sql.NullString
+			dev        wifi.Device
+			hd         wifi.HumanDevice
+		)
+		err = rows.Scan(&name, &dev.MAC, &randomized, &pseudo_id, &hd.Probability, &hd.Provenance)
+		if err != nil {
+			return nil, err
+		}
+		dev.Randomized, dev.PseudoID, hd.MAC = randomized.Bool, pseudo_id.String, dev.MAC
+		if human == nil || human.Name != name {
+			human = &wifi.Human{Name: name}
+			humans.Humans = append(humans.Humans, human)
+		}
+		human.Devices = append(human.Devices, &dev)
+		human.Mappings = append(human.Mappings, &hd)
+	}
+	return humans, rows.Err()
+}
+
+// a single human by name
+func (st *sqlStore) Human(ctx context.Context, name string) (*wifi.Human, error) {
+	humans, err := st.GetHumans(ctx, &wifi.HumanQuery{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	if len(humans.Humans) == 0 {
+		return nil, fmt.Errorf("unknown human '%s'", name)
+	}
+	return humans.Humans[0], nil
+}
+
+// wait for data to be persisted
+func (st *sqlStore) Wait() {
+	<-st.flush_done
+}
+
+// collect devices into batches of BatchSize, or what arrived within
+// BatchDelay, one transaction each
+func (st *sqlStore) sql_io(ctx context.Context) {
+	var (
+		batch   []*wifi.Device
+		timeout <-chan time.Time
+	)
+	log_stats := time.NewTicker(st.conf.LogStatsEvery)
+	defer log_stats.Stop()
+	flush := func() {
+		if len(batch) != 0 {
+			st.storeBatch(batch)
+		}
+		batch, timeout = nil, nil
+	}
+	for {
+		select {
+		case dev := <-st.push:
+			if len(batch) == 0 {
+				timeout = time.After(st.conf.BatchDelay)
+			}
+			batch = append(batch, dev)
+			if len(batch) >= st.conf.BatchSize {
+				flush()
+			}
+		case <-timeout:
+			flush()
+		case <-log_stats.C:
+			st.stats.log(len(st.push), cap(st.push))
+		case <-ctx.Done():
+			flush()
+			st.shutdown()
+			return
+		}
+	}
+}
+
+// what sql_io does for ReadOnly stores, there is nothing to write
+func (st *sqlStore) closeWhenDone(ctx context.Context) {
+	<-ctx.Done()
+	st.db.Close()
+	st.flush_done <- true
+}
+
+func (st *sqlStore) shutdown() {
+	var batch []*wifi.Device
+endfor:
+	for {
+		select {
+		case dev := <-st.push:
+			batch = append(batch, dev)
+		default:
+			break endfor
+		}
+	}
+	if len(batch) != 0 {
+		st.storeBatch(batch)
+	}
+	st.stats.log(0, cap(st.push))
+	log.Print("closing db")
+	st.stmts.close()
+	st.db.Close()
+	st.flush_done <- true
+}
+
+// the statements used for every device, prepared once
+type storeStmts struct {
+	selectNode      *sql.Stmt
+	insertNode      *sql.Stmt
+	updatePseudoID  *sql.Stmt
+	insertDataPoint *sql.Stmt
+	insertFP        *sql.Stmt
+	insertSSID      *sql.Stmt
+	insertSummary   *sql.Stmt
+}
+
+func (st *sqlStore) prepare() (err error) {
+	for _, p := range []struct {
+		stmt **sql.Stmt
+		sql  string
+	}{
+		{&st.stmts.selectNode, "SELECT id, pseudo_id IS NOT NULL FROM nodes WHERE addr = ?"},
+		// NewMapping may have inserted it meanwhile
+		{&st.stmts.insertNode, "INSERT OR IGNORE INTO nodes(addr, randomized, pseudo_id) VALUES(?, ?, ?)"},
+		// the first link wins, keeps the pseudo device stable across restarts
+		{&st.stmts.updatePseudoID, "UPDATE nodes SET pseudo_id = ? WHERE id = ? AND pseudo_id IS NULL"},
+		// resent batches from remote collectors hit unique_dps
+		{&st.stmts.insertDataPoint, `INSERT OR IGNORE
+      INTO datapoints(time, frequency, signal, longitude, latitude, node_id,
+        sequence, tuned_frequency, tuned_channel, channel_width,
+        altitude, accuracy, fix_age)
+      VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&st.stmts.insertFP, `INSERT OR IGNORE
+    INTO fingerprints(digest, rates, ht_capabs, vht_capabs, he_capabs, ext_capabs, vendor_ouis, ie_ids, node_id)
+    VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&st.stmts.insertSSID, "INSERT OR IGNORE INTO probed_ssids(ssid, node_id) VALUES(?, ?)"},
+		{&st.stmts.insertSummary, `INSERT OR IGNORE
+      INTO summaries(node_id, first_seen, last_seen, frames, min_signal, max_signal, mean_signal)
+      VALUES(?, ?, ?, ?, ?, ?, ?)`},
+	} {
+		*p.stmt, err = st.db.Prepare(st.q(p.sql))
+		if err != nil {
+			return fmt.Errorf("preparing '%s': %v", p.sql, err)
+		}
+	}
+	return
+}
+
+func (s *storeStmts) close() {
+	for _, stmt := range []*sql.Stmt{s.selectNode, s.insertNode, s.updatePseudoID,
+		s.insertDataPoint, s.insertFP, s.insertSSID, s.insertSummary} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// runs st.stmts within a transaction
+type txStmts struct {
+	tx *sql.Tx
+}
+
+func (t txStmts) exec(stmt *sql.Stmt, args ...interface{}) (sql.Result, error) {
+	return t.tx.Stmt(stmt).Exec(args...)
+}
+
+// store <devs> in one transaction. If any device fails, each is retried in
+// its own (postgres aborts the whole transaction on errors) and failing
+// devices are logged and skipped.
+func (st *sqlStore) storeBatch(devs []*wifi.Device) {
+	start := time.Now()
+	var ndps int
+	tx, err := st.db.Begin()
+	if err != nil {
+		log.Printf("failed to store %d devices: %v, data is lost now!", len(devs), err)
+		st.stats.failed(len(devs))
+		return
+	}
+	nodes := st.nodes.begin()
+	for _, dev := range devs {
+		err = st.storeTx(txStmts{tx}, nodes, dev)
+		if err != nil {
+			break
+		}
+		ndps += len(dev.GetDataPoints())
+	}
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+	if err == nil {
+		st.nodes.commit(nodes)
+		st.stats.stored(len(devs), ndps, time.Since(start))
+		return
+	}
+	log.Printf("batch of %d devices failed: %v, storing them one by one", len(devs), err)
+	for _, dev := range devs {
+		start = time.Now()
+		if err = st.store(dev); err != nil {
+			log.Printf("failed to store: %v, %v, data is lost now!", dev, err)
+			st.stats.failed(1)
+			continue
+		}
+		st.stats.stored(1, len(dev.GetDataPoints()), time.Since(start))
+	}
+}
+
+// a single device, in its own transaction
+func (st *sqlStore) store(dev *wifi.Device) (err error) {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	nodes := st.nodes.begin()
+	if err = st.storeTx(txStmts{tx}, nodes, dev); err != nil {
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	st.nodes.commit(nodes)
+	return
+}
+
+func (st *sqlStore) storeTx(tx txStmts, nodes *nodeTx, dev *wifi.Device) (err error) {
+	if dev == nil || dev.MAC == "" {
+		return errors.New("dude filter your shit")
+	}
+
+	// insert a new node, or get the node id from the cache or via Query()
+	node, ok := nodes.get(dev.MAC)
+	if !ok {
+		selectNode := tx.tx.Stmt(st.stmts.selectNode)
+		err = selectNode.QueryRow(dev.MAC).Scan(&node.id, &node.linked)
+		if err == sql.ErrNoRows {
+			_, err = tx.exec(st.stmts.insertNode, dev.MAC, dev.Randomized, nullString(dev.PseudoID))
+			if err != nil {
+				return
+			}
+			err = selectNode.QueryRow(dev.MAC).Scan(&node.id, &node.linked)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if !node.linked && dev.PseudoID != "" {
+		_, err = tx.exec(st.stmts.updatePseudoID, dev.PseudoID, node.id)
+		if err != nil {
+			return
+		}
+		node.linked = true
+	}
+	nodes.put(dev.MAC, node)
+
+	if node.id == 0 {
+		return errors.New("no node_id found!")
+	}
+
+	if dev.Fingerprint != nil {
+		err = st.storeFingerprint(tx, node.id, dev.Fingerprint)
+		if err != nil {
+			return fmt.Errorf("insert fingerprint failed: %v", err)
+		}
+	}
+
+	// insert device info
+	for _, dp := range dev.GetDataPoints() {
+		loc := dp.GetLocation() // remote collectors may not send one
+		_, err = tx.exec(st.stmts.insertDataPoint,
+			dp.TimeStamp, dp.Frequency, dp.Signal, loc.GetLon(), loc.GetLat(), node.id,
+			dp.Sequence, dp.TunedFrequency, dp.TunedChannel, dp.ChannelWidth,
+			loc.GetAlt(), loc.GetAcc(), loc.GetFixAge())
+		if err != nil {
+			return fmt.Errorf("insert datapoint failed: %v", err)
+		}
+		if sum := dp.GetSummary(); sum != nil {
+			_, err = tx.exec(st.stmts.insertSummary, node.id, sum.FirstSeen, sum.LastSeen,
+				sum.Frames, sum.MinSignal, sum.MaxSignal, sum.MeanSignal)
+			if err != nil {
+				return fmt.Errorf("insert summary failed: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (st *sqlStore) storeFingerprint(tx txStmts, node_id int64, fp *wifi.Fingerprint) (err error) {
+	_, err = tx.exec(st.stmts.insertFP,
+		fp.Digest(), fp.Rates, fp.HTCapabilities, fp.VHTCapabilities, fp.HECapabilities,
+		fp.ExtCapabilities, joinOUIs(fp.VendorOUIs), fp.IEIDs, node_id)
+	if err != nil {
+		return
+	}
+	for _, ssid := range fp.SSIDs {
+		_, err = tx.exec(st.stmts.insertSSID, ssid, node_id)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// the most recent fingerprint of a device, together with every SSID it ever
+// probed for
+func (st *sqlStore) GetFingerprint(ctx context.Context, dev *wifi.Device) (*wifi.Fingerprint, error) {
+	var (
+		fp      wifi.Fingerprint
+		node_id int64
+		ouis    string
+	)
+	err := st.db.QueryRowContext(ctx, st.q("SELECT id FROM nodes WHERE addr = ?"), dev.GetMAC()).Scan(&node_id)
+	if err != nil {
+		return nil, fmt.Errorf("unknown device '%s': %v", dev.GetMAC(), err)
+	}
+	err = st.db.QueryRowContext(ctx, st.q(`SELECT rates, ht_capabs, vht_capabs, he_capabs, ext_capabs, vendor_ouis, ie_ids
+    FROM fingerprints WHERE node_id = ? ORDER BY id DESC LIMIT 1`), node_id).Scan(
+		&fp.Rates, &fp.HTCapabilities, &fp.VHTCapabilities, &fp.HECapabilities,
+		&fp.ExtCapabilities, &ouis, &fp.IEIDs)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	fp.VendorOUIs, err = splitOUIs(ouis)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := st.db.QueryContext(ctx, st.q("SELECT ssid FROM probed_ssids WHERE node_id = ? ORDER BY id"), node_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ssid string
+		if err = rows.Scan(&ssid); err != nil {
+			return nil, err
+		}
+		fp.SSIDs = append(fp.SSIDs, ssid)
+	}
+	return &fp, rows.Err()
+}
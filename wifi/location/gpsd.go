@@ -0,0 +1,146 @@
+package location
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+const GPSDAddrDefault = "localhost:2947"
+
+// user equivalent range error, turns a DOP into meters
+const UERE = 5.0
+
+// poll interval of the context while waiting for gpsd
+const gpsdReadTimeout = time.Second
+
+// A Source reading TPV/SKY reports from gpsd's JSON protocol, reconnects on
+// the next call after errors.
+type GPSD struct {
+	Addr   string
+	conn   net.Conn
+	reader *bufio.Reader
+	// a line interrupted by the read timeout
+	pending []byte
+	// from the last SKY report, fallback for the accuracy
+	hdop float64
+}
+
+// GPSDAddrDefault if <addr> is empty
+func NewGPSD(addr string) *GPSD {
+	if addr == "" {
+		addr = GPSDAddrDefault
+	}
+	return &GPSD{Addr: addr}
+}
+
+// the fields we care about of all report classes
+type gpsdReport struct {
+	Class string
+	// TPV
+	Mode   int
+	Time   time.Time
+	Lat    float64
+	Lon    float64
+	Alt    float64 // deprecated since gpsd 3.20
+	AltHAE float64
+	Eph    float64
+	Epx    float64
+	Epy    float64
+	Speed  float64
+	Track  float64
+	// SKY
+	Hdop float64
+}
+
+func (g *GPSD) connect(ctx context.Context) (err error) {
+	var d net.Dialer
+	g.conn, err = d.DialContext(ctx, "tcp", g.Addr)
+	if err != nil {
+		return
+	}
+	_, err = g.conn.Write([]byte(`?WATCH={"enable":true,"json":true};` + "\n"))
+	if err != nil {
+		g.Close()
+		return
+	}
+	g.reader = bufio.NewReader(g.conn)
+	g.pending = nil
+	return
+}
+
+func (g *GPSD) Close() {
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+}
+
+// the next TPV report with at least a 2D fix
+func (g *GPSD) Next(ctx context.Context) (*Location, error) {
+	if g.conn == nil {
+		if err := g.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		if ctx.Err() != nil {
+			g.Close()
+			return nil, ctx.Err()
+		}
+		g.conn.SetReadDeadline(time.Now().Add(gpsdReadTimeout))
+		chunk, err := g.reader.ReadBytes('\n')
+		g.pending = append(g.pending, chunk...)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			continue
+		} else if err != nil {
+			g.Close()
+			return nil, fmt.Errorf("gpsd at %s: %v", g.Addr, err)
+		}
+		line := g.pending
+		g.pending = nil
+		var r gpsdReport
+		if json.Unmarshal(line, &r) != nil {
+			continue
+		}
+		switch r.Class {
+		case "SKY":
+			if r.Hdop != 0 {
+				g.hdop = r.Hdop
+			}
+		case "TPV":
+			if r.Mode >= 2 {
+				return g.location(&r), nil
+			}
+		}
+	}
+}
+
+func (g *GPSD) location(r *gpsdReport) *Location {
+	loc := &Location{
+		Stamp:   r.Time,
+		Lat:     float32(r.Lat),
+		Lon:     float32(r.Lon),
+		Alt:     r.AltHAE,
+		Speed:   r.Speed,
+		Bearing: r.Track,
+	}
+	if loc.Alt == 0 {
+		loc.Alt = r.Alt
+	}
+	switch {
+	case r.Eph != 0:
+		loc.Acc = r.Eph
+	case r.Epx != 0 || r.Epy != 0:
+		loc.Acc = r.Epx
+		if r.Epy > r.Epx {
+			loc.Acc = r.Epy
+		}
+	default:
+		loc.Acc = g.hdop * UERE
+	}
+	return loc
+}
@@ -0,0 +1,100 @@
+package location
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// accepts one client, waits for ?WATCH and sends <reports>, slowly
+func fakeGPSD(t *testing.T, reports ...string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte(`{"class":"VERSION","release":"3.22","proto_major":3}` + "\n"))
+		watch, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || !strings.HasPrefix(watch, "?WATCH=") {
+			t.Errorf("expected ?WATCH, got '%s', %v", watch, err)
+			return
+		}
+		for _, r := range reports {
+			// split lines, gpsd doesn't promise whole lines per packet
+			half := len(r) / 2
+			conn.Write([]byte(r[:half]))
+			time.Sleep(time.Millisecond * 5)
+			conn.Write([]byte(r[half:] + "\n"))
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestGPSD(t *testing.T) {
+	addr := fakeGPSD(t,
+		`{"class":"DEVICES","devices":[{"class":"DEVICE","path":"/dev/ttyUSB0"}]}`,
+		`{"class":"TPV","device":"/dev/ttyUSB0","mode":1}`,
+		`{"class":"SKY","hdop":1.2,"satellites":[]}`,
+		`{"class":"TPV","mode":3,"time":"2020-01-01T12:00:00.000Z","lat":48.1,"lon":11.5,"altHAE":520.5,"eph":7.5,"speed":1.5,"track":90.0}`,
+		`{"class":"TPV","mode":2,"time":"2020-01-01T12:00:01.000Z","lat":48.2,"lon":11.6}`,
+	)
+	g := NewGPSD(addr)
+	defer g.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	loc, err := g.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Location{
+		Stamp: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+		Lat:   48.1, Lon: 11.5, Alt: 520.5, Acc: 7.5, Speed: 1.5, Bearing: 90,
+	}
+	if !loc.Stamp.Equal(expected.Stamp) {
+		t.Errorf("got stamp %v", loc.Stamp)
+	}
+	loc.Stamp = expected.Stamp
+	if *loc != expected {
+		t.Errorf("got %+v, expected %+v", loc, expected)
+	}
+
+	loc, err = g.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Lat != 48.2 || loc.Acc != 1.2*UERE {
+		t.Errorf("2D fix: got %+v", loc)
+	}
+
+	if _, err = g.Next(ctx); err == nil {
+		t.Error("expected an error after gpsd went away")
+	}
+}
+
+func TestProviderWithSource(t *testing.T) {
+	addr := fakeGPSD(t,
+		`{"class":"TPV","mode":2,"time":"2020-01-01T12:00:00.000Z","lat":48.0,"lon":11.0}`,
+		`{"class":"TPV","mode":2,"time":"2020-01-01T12:00:10.000Z","lat":49.0,"lon":12.0}`,
+	)
+	p := NewProvider(Config{Source: NewGPSD(addr), UpdateInterval: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+	for i := 0; i < 100 && p.track.Len() < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	loc := p.LocationAt(time.Date(2020, 1, 1, 12, 0, 5, 0, time.UTC))
+	if loc == nil || loc.Lat != 48.5 || loc.Lon != 11.5 {
+		t.Errorf("got %+v", loc)
+	}
+}
@@ -2,16 +2,24 @@ package location
 
 import (
 	"context"
-	"encoding/json"
+	"io"
 	"log"
-	"os/exec"
 	"sync"
 	"time"
 )
 
+const TrackMaxAgeDefault = time.Hour
+const UpdateIntervalDefault = time.Second * 5
+
 type Config struct {
+	// how often to poll sources that have to be asked, e.g. termux-location,
+	// UpdateIntervalDefault if 0
 	UpdateInterval    time.Duration
 	updateWaitTimeout time.Duration
+	// how much history to keep for LocationAt(), TrackMaxAgeDefault if 0
+	TrackMaxAge time.Duration
+	// where fixes come from, termux-location if nil
+	Source Source
 }
 
 type Location struct {
@@ -20,62 +28,41 @@ type Location struct {
 	Lon   float32
 	Alt   float64
 	Acc   float64
+	// meters per second
+	Speed float64
+	// degrees, clockwise from true north
+	Bearing float64
+	// only set by Locator's: distance in time to the nearest real fix
+	Age time.Duration
+}
+
+// where fixes come from
+type Source interface {
+	// block until the next fix is available, or ctx is Done()
+	Next(ctx context.Context) (*Location, error)
 }
 
 type Provider struct {
 	Conf         Config
 	m            sync.Mutex
 	lastLocation *Location
+	track        *Track
 }
 
 func NewProvider(c Config) *Provider {
+	if c.UpdateInterval == 0 {
+		c.UpdateInterval = UpdateIntervalDefault
+	}
 	c.updateWaitTimeout = c.UpdateInterval / 2
-	return &Provider{
-		Conf: c,
+	if c.TrackMaxAge == 0 {
+		c.TrackMaxAge = TrackMaxAgeDefault
 	}
-}
-
-/*
-location format:
-```
-u0_a142:~$ termux-location
-{
-	"latitude": 48.77833406,
-	"longitude": 11.43595288,
-	"altitude": 434.0,
-	"accuracy": 28.0,
-	"vertical_accuracy": 0.0,
-	"bearing": 0.0,
-	"speed": 0.0,
-	"elapsedMs": 12,
-	"provider": "gps"
-}
-```
-*/
-type termuxLocation struct {
-	Latitude          float32
-	Longitude         float32
-	Altitude          float64
-	Accuracy          float64
-	Vertical_accuracy float64
-	Bearing           float64
-	Speed             float64
-	ElapsedMs         uint32
-	//Provider string
-}
-
-func parseJsonLocation(in []byte) *Location {
-	tmp := termuxLocation{}
-	err := json.Unmarshal(in, &tmp)
-	if err != nil {
-		log.Printf("json.Unmarshal of '%v' failed: %v", in, err)
-		return nil
+	if c.Source == nil {
+		c.Source = &termux{interval: c.UpdateInterval, timeout: c.updateWaitTimeout / 2}
 	}
-	return &Location{
-		Lon: tmp.Longitude,
-		Lat: tmp.Latitude,
-		Alt: tmp.Altitude,
-		Acc: tmp.Accuracy,
+	return &Provider{
+		Conf:  c,
+		track: NewTrack(c.TrackMaxAge),
 	}
 }
 
@@ -85,35 +72,44 @@ func (p *Provider) RetrieveLocation() *Location {
 	return p.lastLocation
 }
 
-func (p *Provider) updateLocation() {
-	ctx, cancel := context.WithTimeout(context.Background(), p.Conf.updateWaitTimeout/2)
-	defer cancel()
-	cmdline := "termux-location"
-	cmd := exec.CommandContext(ctx, cmdline)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("execution of '%s' failed (killed=timeout): %v", cmdline, err)
-		return
-	}
-	now := time.Now()
-	newLocation := parseJsonLocation(out)
-	if newLocation != nil {
-		newLocation.Stamp = now
-		p.m.Lock()
-		defer p.m.Unlock()
-		p.lastLocation = newLocation
+// implements Locator, interpolated from the fixes of the last
+// Config.TrackMaxAge
+func (p *Provider) LocationAt(t time.Time) *Location {
+	return p.track.LocationAt(t)
+}
+
+func (p *Provider) updateLocation(newLocation *Location) {
+	if newLocation.Stamp.IsZero() {
+		newLocation.Stamp = time.Now()
 	}
+	p.track.Add(newLocation)
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.lastLocation = newLocation
 }
 
 // continuously retrieve location
 // should be run is a goroutine like "go p.Run()"
 func (p *Provider) Run(ctx context.Context) {
-	ticker := time.NewTicker(p.Conf.UpdateInterval)
 	for {
-		select {
-		case <-ctx.Done():
-		case <-ticker.C:
-			p.updateLocation()
+		loc, err := p.Conf.Source.Next(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == io.EOF {
+			log.Printf("location source ended")
+			return
+		}
+		if err != nil {
+			log.Printf("location source failed: %v", err)
+			// don't spin on broken sources
+			select {
+			case <-time.After(p.Conf.UpdateInterval):
+			case <-ctx.Done():
+				return
+			}
+			continue
 		}
+		p.updateLocation(loc)
 	}
 }
@@ -0,0 +1,304 @@
+package location
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const knots = 1852.0 / 3600 // in m/s
+
+// A Source parsing NMEA 0183 (GGA, RMC, GSA and VTG) from a serial device or
+// a recorded log. Serial devices have to be configured beforehand, e.g.
+// `stty -F /dev/ttyUSB0 4800 raw`.
+//
+// Sentences are grouped by their UTC time, a fix is returned once the next
+// group starts (or the input ends), so it is a cycle late but complete.
+type NMEA struct {
+	// empty for NewNMEAReader
+	Path string
+	// Path is a regular file, a log that ends
+	logFile bool
+	rc      io.ReadCloser
+	reader  *bufio.Reader
+	// the group being collected
+	epoch nmeaEpoch
+	// from the last RMC, today if there never was one
+	date time.Time
+	// time of day of the last group, to notice midnight without RMC
+	lastClock time.Duration
+	// from the last GGA or GSA, fallback for the accuracy
+	hdop float64
+}
+
+type nmeaEpoch struct {
+	clock   string
+	tod     time.Duration
+	dated   bool // an RMC set the date
+	fix     bool
+	lat     float64
+	lon     float64
+	alt     float64
+	hdop    float64
+	speed   float64
+	bearing float64
+	moving  bool // speed and bearing are set
+}
+
+// (re)opens <path> on errors, unless it is a regular file, which is read
+// once like NewNMEAReader
+func NewNMEA(path string) *NMEA {
+	return &NMEA{Path: path}
+}
+
+// a recorded log, Next() returns io.EOF at its end
+func NewNMEAReader(r io.Reader) *NMEA {
+	rc, ok := r.(io.ReadCloser)
+	if !ok {
+		rc = ioutil.NopCloser(r)
+	}
+	return &NMEA{rc: rc, reader: bufio.NewReader(rc)}
+}
+
+func (n *NMEA) Close() {
+	if n.rc != nil {
+		n.rc.Close()
+		n.rc = nil
+	}
+}
+
+func (n *NMEA) Next(ctx context.Context) (*Location, error) {
+	if n.rc == nil {
+		if n.Path == "" || n.logFile {
+			return nil, io.EOF
+		}
+		f, err := os.Open(n.Path)
+		if err != nil {
+			return nil, err
+		}
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			n.logFile = true
+		}
+		n.rc, n.reader = f, bufio.NewReader(f)
+	}
+	// a blocking read only returns if the file is closed
+	rc, stop := n.rc, make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			rc.Close()
+		case <-stop:
+		}
+	}()
+	for {
+		line, err := n.reader.ReadString('\n')
+		if loc := n.handle(line); loc != nil {
+			return loc, nil
+		}
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if err == io.EOF {
+			if loc := n.flush(); loc != nil {
+				return loc, nil
+			}
+		}
+		n.Close()
+		return nil, err
+	}
+}
+
+// feed one sentence, returns the previous group if this one starts a new
+func (n *NMEA) handle(line string) (done *Location) {
+	typ, f, err := parseSentence(line)
+	if err != nil {
+		return nil
+	}
+	switch typ {
+	case "GGA":
+		if len(f) < 12 {
+			return nil
+		}
+		done = n.startEpoch(f[1])
+		if f[6] == "" || f[6] == "0" {
+			return
+		}
+		lat, lon, err := parseLatLon(f[2], f[3], f[4], f[5])
+		if err != nil {
+			return
+		}
+		e := &n.epoch
+		e.fix, e.lat, e.lon = true, lat, lon
+		e.hdop = parseFloat(f[8])
+		// height above the ellipsoid, like the other sources
+		e.alt = parseFloat(f[9]) + parseFloat(f[11])
+	case "RMC":
+		if len(f) < 10 {
+			return nil
+		}
+		done = n.startEpoch(f[1])
+		if d, err := time.Parse("020106", f[9]); err == nil {
+			n.date = d
+			n.epoch.dated = true
+		}
+		if f[2] != "A" {
+			return
+		}
+		lat, lon, err := parseLatLon(f[3], f[4], f[5], f[6])
+		if err != nil {
+			return
+		}
+		e := &n.epoch
+		if !e.fix {
+			e.fix, e.lat, e.lon = true, lat, lon
+		}
+		e.speed = parseFloat(f[7]) * knots
+		e.bearing = parseFloat(f[8])
+		e.moving = true
+	case "GSA":
+		if len(f) < 17 {
+			return nil
+		}
+		if n.epoch.hdop == 0 {
+			n.epoch.hdop = parseFloat(f[16])
+		}
+	case "VTG":
+		if len(f) < 8 || n.epoch.moving {
+			return nil
+		}
+		n.epoch.bearing = parseFloat(f[1])
+		if f[7] != "" {
+			n.epoch.speed = parseFloat(f[7]) / 3.6
+		} else {
+			n.epoch.speed = parseFloat(f[5]) * knots
+		}
+		n.epoch.moving = true
+	}
+	return
+}
+
+// start a new group if <clock> differs from the current one
+func (n *NMEA) startEpoch(clock string) *Location {
+	if clock == n.epoch.clock {
+		return nil
+	}
+	done := n.flush()
+	n.epoch = nmeaEpoch{clock: clock, tod: parseClock(clock)}
+	return done
+}
+
+// the current group as Location, nil without a fix
+func (n *NMEA) flush() *Location {
+	e := n.epoch
+	n.epoch = nmeaEpoch{}
+	if e.hdop != 0 {
+		n.hdop = e.hdop
+	}
+	if e.clock == "" {
+		return nil
+	}
+	if n.date.IsZero() {
+		n.date = time.Now().UTC().Truncate(time.Hour * 24)
+	} else if !e.dated && e.tod < n.lastClock-time.Hour*12 {
+		// midnight passed without an RMC telling us
+		n.date = n.date.Add(time.Hour * 24)
+	}
+	n.lastClock = e.tod
+	if !e.fix {
+		return nil
+	}
+	return &Location{
+		Stamp:   n.date.Add(e.tod),
+		Lat:     float32(e.lat),
+		Lon:     float32(e.lon),
+		Alt:     e.alt,
+		Acc:     n.hdop * UERE,
+		Speed:   e.speed,
+		Bearing: e.bearing,
+	}
+}
+
+// "$GPGGA,...*47" to "GGA" and its fields, talker and checksum removed
+func parseSentence(line string) (typ string, fields []string, err error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "$") {
+		return "", nil, fmt.Errorf("not a sentence: '%s'", line)
+	}
+	body := line[1:]
+	if i := strings.LastIndex(body, "*"); i >= 0 {
+		want, err := strconv.ParseUint(body[i+1:], 16, 8)
+		if err != nil {
+			return "", nil, fmt.Errorf("bad checksum in '%s'", line)
+		}
+		body = body[:i]
+		var sum byte
+		for i := 0; i < len(body); i++ {
+			sum ^= body[i]
+		}
+		if sum != byte(want) {
+			return "", nil, fmt.Errorf("checksum mismatch in '%s'", line)
+		}
+	}
+	fields = strings.Split(body, ",")
+	if len(fields[0]) != 5 || fields[0][0] == 'P' {
+		// proprietary or garbage
+		return "", nil, fmt.Errorf("unsupported sentence '%s'", line)
+	}
+	return fields[0][2:], fields, nil
+}
+
+// "4807.038", "N", "01131.000", "E"
+func parseLatLon(lat, ns, lon, ew string) (float64, float64, error) {
+	la, err := parseDegrees(lat)
+	if err != nil {
+		return 0, 0, err
+	}
+	lo, err := parseDegrees(lon)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ns == "S" {
+		la = -la
+	}
+	if ew == "W" {
+		lo = -lo
+	}
+	return la, lo, nil
+}
+
+// [d]ddmm.mmmm
+func parseDegrees(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	deg := float64(int(v / 100))
+	return deg + (v-deg*100)/60, nil
+}
+
+// hhmmss[.ss]
+func parseClock(s string) time.Duration {
+	if len(s) < 6 {
+		return 0
+	}
+	h, _ := strconv.Atoi(s[0:2])
+	m, _ := strconv.Atoi(s[2:4])
+	sec, _ := strconv.ParseFloat(s[4:], 64)
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(sec*float64(time.Second))
+}
+
+// empty fields are 0
+func parseFloat(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
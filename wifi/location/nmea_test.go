@@ -0,0 +1,105 @@
+package location
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNMEALog(t *testing.T) {
+	f, err := os.Open("testdata/ublox.nmea")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewNMEAReader(f)
+	defer n.Close()
+	day := time.Date(1994, 3, 23, 0, 0, 0, 0, time.UTC)
+	expected := []Location{
+		// GGA+GSA+RMC, VTG is ignored in favour of RMC
+		{Stamp: day.Add(time.Hour*12 + time.Minute*35 + time.Second*19),
+			Lat: 48.1173, Lon: 11.516667, Alt: 592.3, Acc: 0.9 * UERE,
+			Speed: 22.4 * knots, Bearing: 84.4},
+		// GGA with a bad checksum, RMC+GSA
+		{Stamp: day.Add(time.Hour*12 + time.Minute*35 + time.Second*20),
+			Lat: 48.118333, Lon: 11.518333, Acc: 1.4 * UERE},
+		// no fix at 12:35:21, GGA+VTG, flushed at the end of the log
+		{Stamp: day.Add(time.Hour*12 + time.Minute*35 + time.Second*22),
+			Lat: -33.85, Lon: -151.2, Alt: 30, Acc: 2 * UERE,
+			Speed: 10, Bearing: 270},
+	}
+	for i, e := range expected {
+		loc, err := n.Next(context.Background())
+		if err != nil {
+			t.Fatalf("fix %d: %v", i, err)
+		}
+		if !loc.Stamp.Equal(e.Stamp) || !near(loc.Lat, e.Lat) || !near(loc.Lon, e.Lon) ||
+			!near(float32(loc.Alt), float32(e.Alt)) || !near(float32(loc.Acc), float32(e.Acc)) ||
+			!near(float32(loc.Speed), float32(e.Speed)) || !near(float32(loc.Bearing), float32(e.Bearing)) {
+			t.Errorf("fix %d: got %+v, expected %+v", i, loc, e)
+		}
+	}
+	if _, err = n.Next(context.Background()); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestParseSentence(t *testing.T) {
+	for line, ok := range map[string]bool{
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47": true,
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*48": false,
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,":    true,
+		"$PGRME,15.0,M,45.0,M,25.0,M*1C":                                    false,
+		"GPGGA,123519":                                                      false,
+	} {
+		if _, _, err := parseSentence(line); (err == nil) != ok {
+			t.Errorf("'%s': %v", line, err)
+		}
+	}
+}
+
+func TestNMEAMidnight(t *testing.T) {
+	n := NewNMEA("")
+	n.date = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	n.handle("$GPGGA,235959.00,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,")
+	n.handle("$GPGGA,000000.00,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,")
+	loc := n.flush()
+	if loc == nil || !loc.Stamp.Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v", loc)
+	}
+}
+
+func TestNMEAMidnightRMC(t *testing.T) {
+	n := NewNMEA("")
+	n.handle("$GPGGA,235959.00,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,")
+	n.handle("$GPRMC,235959.00,A,4807.038,N,01131.000,E,022.4,084.4,010120,003.1,W")
+	n.handle("$GPGGA,000000.00,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,")
+	n.handle("$GPRMC,000000.00,A,4807.038,N,01131.000,E,022.4,084.4,020120,003.1,W")
+	loc := n.flush()
+	if loc == nil || !loc.Stamp.Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v", loc)
+	}
+}
+
+func TestNMEALogFile(t *testing.T) {
+	n := NewNMEA("testdata/ublox.nmea")
+	defer n.Close()
+	fixes := 0
+	for {
+		_, err := n.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixes++
+	}
+	if fixes != 3 {
+		t.Errorf("%d fixes", fixes)
+	}
+	if _, err := n.Next(context.Background()); err != io.EOF {
+		t.Errorf("log reopened, got %v", err)
+	}
+}
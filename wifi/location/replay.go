@@ -0,0 +1,200 @@
+package location
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Load a track recorded by a separate GPS logger, to georeference captures
+// after the fact. The format is guessed from the extension: ".gpx" or
+// ".geojson"/".json".
+func LoadTrack(path string) (*Track, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var t *Track
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gpx":
+		t, err = ReadGPX(f)
+	case ".geojson", ".json":
+		t, err = ReadGeoJSON(f)
+	default:
+		return nil, fmt.Errorf("%s: unknown track format", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return t, nil
+}
+
+type gpxPoint struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lon  float64   `xml:"lon,attr"`
+	Ele  float64   `xml:"ele"`
+	Time time.Time `xml:"time"`
+	Hdop float64   `xml:"hdop"`
+	// GPX 1.0 only, 1.1 moved them into vendor specific extensions
+	Speed  float64 `xml:"speed"`
+	Course float64 `xml:"course"`
+}
+
+type gpx struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// all track points with a time, of all tracks and segments
+func ReadGPX(r io.Reader) (*Track, error) {
+	var doc gpx
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	t := NewTrack(0)
+	for _, trk := range doc.Tracks {
+		for _, seg := range trk.Segments {
+			for _, p := range seg.Points {
+				if p.Time.IsZero() {
+					continue
+				}
+				t.Add(&Location{
+					Stamp:   p.Time,
+					Lat:     float32(p.Lat),
+					Lon:     float32(p.Lon),
+					Alt:     p.Ele,
+					Acc:     p.Hdop * UERE,
+					Speed:   p.Speed,
+					Bearing: p.Course,
+				})
+			}
+		}
+	}
+	if t.Len() == 0 {
+		return nil, fmt.Errorf("no track points with a time")
+	}
+	return t, nil
+}
+
+// any GeoJSON object, only (Multi)LineStrings are used
+type geoJSON struct {
+	Type        string
+	Features    []*geoJSON
+	Geometry    *geoJSON
+	Geometries  []*geoJSON
+	Coordinates json.RawMessage
+	Properties  struct {
+		// as written by togeojson and friends, per coordinate
+		CoordTimes json.RawMessage `json:"coordTimes"`
+	}
+}
+
+// LineStrings and MultiLineStrings in a Feature(Collection) or bare. The
+// time of a coordinate comes from the features "coordTimes" property or the
+// 4th coordinate (unix time in seconds or milliseconds).
+func ReadGeoJSON(r io.Reader) (*Track, error) {
+	var doc geoJSON
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	t := NewTrack(0)
+	if err := doc.addTo(t, nil); err != nil {
+		return nil, err
+	}
+	if t.Len() == 0 {
+		return nil, fmt.Errorf("no coordinates with a time")
+	}
+	return t, nil
+}
+
+// <times> are the coordTimes of the enclosing feature
+func (g *geoJSON) addTo(t *Track, times json.RawMessage) error {
+	switch g.Type {
+	case "FeatureCollection":
+		for _, f := range g.Features {
+			if err := f.addTo(t, nil); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if g.Geometry != nil {
+			return g.Geometry.addTo(t, g.Properties.CoordTimes)
+		}
+	case "GeometryCollection":
+		for _, geom := range g.Geometries {
+			if err := geom.addTo(t, nil); err != nil {
+				return err
+			}
+		}
+	case "LineString":
+		var coords [][]float64
+		var stamps []time.Time
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return err
+		}
+		if times != nil {
+			if err := json.Unmarshal(times, &stamps); err != nil {
+				return fmt.Errorf("coordTimes: %v", err)
+			}
+		}
+		return addLine(t, coords, stamps)
+	case "MultiLineString":
+		var lines [][][]float64
+		var stamps [][]time.Time
+		if err := json.Unmarshal(g.Coordinates, &lines); err != nil {
+			return err
+		}
+		if times != nil {
+			if err := json.Unmarshal(times, &stamps); err != nil {
+				return fmt.Errorf("coordTimes: %v", err)
+			}
+		}
+		for i, coords := range lines {
+			var s []time.Time
+			if i < len(stamps) {
+				s = stamps[i]
+			}
+			if err := addLine(t, coords, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// [lon, lat[, ele[, time]]], coordinates without a time are skipped
+func addLine(t *Track, coords [][]float64, stamps []time.Time) error {
+	if stamps != nil && len(stamps) != len(coords) {
+		return fmt.Errorf("%d coordTimes for %d coordinates", len(stamps), len(coords))
+	}
+	for i, c := range coords {
+		if len(c) < 2 {
+			return fmt.Errorf("coordinate %d: %v", i, c)
+		}
+		loc := &Location{Lon: float32(c[0]), Lat: float32(c[1])}
+		if len(c) > 2 {
+			loc.Alt = c[2]
+		}
+		switch {
+		case stamps != nil:
+			loc.Stamp = stamps[i]
+		case len(c) > 3 && c[3] > 1e11:
+			loc.Stamp = time.Unix(0, int64(c[3]*1e6))
+		case len(c) > 3:
+			loc.Stamp = time.Unix(0, int64(c[3]*1e9))
+		default:
+			continue
+		}
+		t.Add(loc)
+	}
+	return nil
+}
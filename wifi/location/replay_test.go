@@ -0,0 +1,54 @@
+package location
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTrack(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, path := range []string{"testdata/logger.gpx", "testdata/logger.geojson"} {
+		tr, err := LoadTrack(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the fix without a time is skipped
+		if tr.Len() != 3 {
+			t.Errorf("%s: expected 3 fixes, got %d", path, tr.Len())
+		}
+		loc := tr.LocationAt(start.Add(time.Second * 15))
+		if loc == nil || !near(loc.Lat, 48.15) || !near(loc.Lon, 11.3) ||
+			!near(float32(loc.Alt), 515) || loc.Age != time.Second*5 {
+			t.Errorf("%s: got %+v", path, loc)
+		}
+		loc = tr.LocationAt(start.Add(-time.Second))
+		if loc == nil || !near(loc.Lat, 48) || loc.Age != time.Second {
+			t.Errorf("%s: before the track got %+v", path, loc)
+		}
+	}
+}
+
+func TestReadGPXExtras(t *testing.T) {
+	tr, err := LoadTrack("testdata/logger.gpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := tr.LocationAt(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	if loc.Acc != UERE || loc.Speed != 1.5 || loc.Bearing != 45 {
+		t.Errorf("got %+v", loc)
+	}
+}
+
+func TestReadGeoJSONErrors(t *testing.T) {
+	for _, doc := range []string{
+		`{"type": "LineString", "coordinates": [[11.0, 48.0]]}`,
+		`{"type": "Feature", "properties": {"coordTimes": ["2020-01-01T12:00:00Z"]},
+		  "geometry": {"type": "LineString", "coordinates": [[11.0, 48.0], [11.1, 48.1]]}}`,
+		`not json`,
+	} {
+		if _, err := ReadGeoJSON(strings.NewReader(doc)); err == nil {
+			t.Errorf("no error for '%s'", doc)
+		}
+	}
+}
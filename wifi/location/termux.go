@@ -0,0 +1,93 @@
+package location
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+)
+
+/*
+location format:
+```
+u0_a142:~$ termux-location
+{
+	"latitude": 48.77833406,
+	"longitude": 11.43595288,
+	"altitude": 434.0,
+	"accuracy": 28.0,
+	"vertical_accuracy": 0.0,
+	"bearing": 0.0,
+	"speed": 0.0,
+	"elapsedMs": 12,
+	"provider": "gps"
+}
+```
+*/
+type termuxLocation struct {
+	Latitude          float32
+	Longitude         float32
+	Altitude          float64
+	Accuracy          float64
+	Vertical_accuracy float64
+	Bearing           float64
+	Speed             float64
+	ElapsedMs         uint32
+	//Provider string
+}
+
+func parseJsonLocation(in []byte) *Location {
+	tmp := termuxLocation{}
+	err := json.Unmarshal(in, &tmp)
+	if err != nil {
+		log.Printf("json.Unmarshal of '%v' failed: %v", in, err)
+		return nil
+	}
+	loc := &Location{
+		Lon:     tmp.Longitude,
+		Lat:     tmp.Latitude,
+		Alt:     tmp.Altitude,
+		Acc:     tmp.Accuracy,
+		Speed:   tmp.Speed,
+		Bearing: tmp.Bearing,
+	}
+	if tmp.ElapsedMs != 0 {
+		// the fix is older than the answer
+		loc.Stamp = time.Now().Add(-time.Duration(tmp.ElapsedMs) * time.Millisecond)
+	}
+	return loc
+}
+
+// asks the termux-api every <interval>
+type termux struct {
+	interval time.Duration
+	timeout  time.Duration
+	ticker   *time.Ticker
+}
+
+func (t *termux) Next(ctx context.Context) (*Location, error) {
+	if t.ticker == nil {
+		t.ticker = time.NewTicker(t.interval)
+	}
+	select {
+	case <-ctx.Done():
+		t.ticker.Stop()
+		return nil, ctx.Err()
+	case <-t.ticker.C:
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	cmdline := "termux-location"
+	cmd := exec.CommandContext(ctx, cmdline)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("execution of '%s' failed (killed=timeout): %v", cmdline, err)
+	}
+	loc := parseJsonLocation(out)
+	if loc == nil {
+		return nil, fmt.Errorf("'%s' returned garbage", cmdline)
+	}
+	return loc, nil
+}
@@ -0,0 +1,117 @@
+package location
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// answers "where was I at time T"
+type Locator interface {
+	// nil if nothing is known
+	LocationAt(t time.Time) *Location
+}
+
+// A time ordered list of fixes, safe for concurrent use.
+type Track struct {
+	// drop fixes this much older than the newest one, 0 keeps everything
+	MaxAge time.Duration
+	mtx    sync.RWMutex
+	fixes  []*Location
+}
+
+func NewTrack(maxAge time.Duration) *Track {
+	return &Track{MaxAge: maxAge}
+}
+
+// fixes usually arrive in order, but don't have to
+func (t *Track) Add(l *Location) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	i := sort.Search(len(t.fixes), func(i int) bool {
+		return t.fixes[i].Stamp.After(l.Stamp)
+	})
+	t.fixes = append(t.fixes, nil)
+	copy(t.fixes[i+1:], t.fixes[i:])
+	t.fixes[i] = l
+	if t.MaxAge == 0 {
+		return
+	}
+	oldest := t.fixes[len(t.fixes)-1].Stamp.Add(-t.MaxAge)
+	drop := sort.Search(len(t.fixes), func(i int) bool {
+		return !t.fixes[i].Stamp.Before(oldest)
+	})
+	t.fixes = t.fixes[drop:]
+}
+
+func (t *Track) Len() int {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return len(t.fixes)
+}
+
+// Interpolated between the surrounding fixes (Stamp is <at> then), outside of
+// the track the nearest fix is returned. Age is the distance to the nearest
+// fix.
+func (t *Track) LocationAt(at time.Time) *Location {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	n := len(t.fixes)
+	if n == 0 {
+		return nil
+	}
+	i := sort.Search(n, func(i int) bool {
+		return t.fixes[i].Stamp.After(at)
+	})
+	switch {
+	case i == 0:
+		return t.fixes[0].aged(t.fixes[0].Stamp.Sub(at))
+	case i == n:
+		return t.fixes[n-1].aged(at.Sub(t.fixes[n-1].Stamp))
+	}
+	return interpolate(t.fixes[i-1], t.fixes[i], at)
+}
+
+// copy of l, as seen <age> later (or earlier)
+func (l *Location) aged(age time.Duration) *Location {
+	c := *l
+	c.Age = age
+	return &c
+}
+
+// linear, which is good enough for the distances between two fixes
+func interpolate(a, b *Location, at time.Time) *Location {
+	span := b.Stamp.Sub(a.Stamp)
+	if span == 0 {
+		return a.aged(0)
+	}
+	f := float64(at.Sub(a.Stamp)) / float64(span)
+	lerp := func(x, y float64) float64 { return x + (y-x)*f }
+	dlon := float64(b.Lon - a.Lon)
+	if dlon > 180 { // crossing the antimeridian
+		dlon -= 360
+	} else if dlon < -180 {
+		dlon += 360
+	}
+	lon := float64(a.Lon) + dlon*f
+	if lon > 180 {
+		lon -= 360
+	} else if lon < -180 {
+		lon += 360
+	}
+	age, nearest := at.Sub(a.Stamp), a
+	if b.Stamp.Sub(at) < age {
+		age, nearest = b.Stamp.Sub(at), b
+	}
+	return &Location{
+		Stamp: at,
+		Lat:   float32(lerp(float64(a.Lat), float64(b.Lat))),
+		Lon:   float32(lon),
+		Alt:   lerp(a.Alt, b.Alt),
+		Acc:   lerp(a.Acc, b.Acc),
+		Speed: lerp(a.Speed, b.Speed),
+		// angles don't lerp well
+		Bearing: nearest.Bearing,
+		Age:     age,
+	}
+}
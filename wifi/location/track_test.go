@@ -0,0 +1,70 @@
+package location
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func fix(after time.Duration, lat, lon float32) *Location {
+	return &Location{Stamp: epoch.Add(after), Lat: lat, Lon: lon, Acc: 10}
+}
+
+func near(a, b float32) bool {
+	return math.Abs(float64(a-b)) < 1e-4
+}
+
+func TestTrackInterpolates(t *testing.T) {
+	tr := NewTrack(0)
+	if tr.LocationAt(epoch) != nil {
+		t.Error("location from an empty track")
+	}
+	// out of order on purpose
+	tr.Add(fix(10*time.Second, 48.2, 11.2))
+	tr.Add(fix(0, 48.0, 11.0))
+	tr.Add(fix(20*time.Second, 48.2, 11.4))
+
+	cases := []struct {
+		at       time.Duration
+		lat, lon float32
+		age      time.Duration
+	}{
+		{-5 * time.Second, 48.0, 11.0, 5 * time.Second},
+		{0, 48.0, 11.0, 0},
+		{2 * time.Second, 48.04, 11.04, 2 * time.Second},
+		{5 * time.Second, 48.1, 11.1, 5 * time.Second},
+		{15 * time.Second, 48.2, 11.3, 5 * time.Second},
+		{18 * time.Second, 48.2, 11.36, 2 * time.Second},
+		{time.Minute, 48.2, 11.4, 40 * time.Second},
+	}
+	for _, c := range cases {
+		l := tr.LocationAt(epoch.Add(c.at))
+		if !near(l.Lat, c.lat) || !near(l.Lon, c.lon) || l.Age != c.age || l.Acc != 10 {
+			t.Errorf("at %v: got %+v, expected %v/%v age %v", c.at, l, c.lat, c.lon, c.age)
+		}
+	}
+}
+
+func TestTrackAntimeridian(t *testing.T) {
+	tr := NewTrack(0)
+	tr.Add(fix(0, 0, 179.5))
+	tr.Add(fix(10*time.Second, 0, -179.5))
+	if l := tr.LocationAt(epoch.Add(5 * time.Second)); !near(l.Lon, 180) && !near(l.Lon, -180) {
+		t.Errorf("expected +-180, got %v", l.Lon)
+	}
+	if l := tr.LocationAt(epoch.Add(8 * time.Second)); !near(l.Lon, -179.7) {
+		t.Errorf("expected -179.7, got %v", l.Lon)
+	}
+}
+
+func TestTrackMaxAge(t *testing.T) {
+	tr := NewTrack(time.Minute)
+	for i := 0; i < 10; i++ {
+		tr.Add(fix(time.Duration(i)*30*time.Second, 0, 0))
+	}
+	if tr.Len() != 3 {
+		t.Errorf("kept %d fixes, expected 3", tr.Len())
+	}
+}
@@ -0,0 +1,249 @@
+// Counters and gauges in the Prometheus text format and via expvar, for
+// dashboards to tell healthy sensors from broken ones.
+//
+// Writes the format itself, instead of pulling in a client library.
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// a single value of a series
+type Sample struct {
+	// e.g. sigint_packets_total, histograms have _bucket, _sum and _count
+	Name string
+	Help string
+	Type string
+	// name, value pairs
+	Labels []string
+	Value  float64
+}
+
+// labels are name, value pairs
+func Counter(name, help string, value float64, labels ...string) Sample {
+	return Sample{Name: name, Help: help, Type: TypeCounter, Labels: labels, Value: value}
+}
+
+func Gauge(name, help string, value float64, labels ...string) Sample {
+	return Sample{Name: name, Help: help, Type: TypeGauge, Labels: labels, Value: value}
+}
+
+// anything with metrics, e.g. a *wifi.Wifi or a local.Store
+type Collector interface {
+	Metrics() []Sample
+}
+
+// a function as Collector
+type Func func() []Sample
+
+func (f Func) Metrics() []Sample {
+	return f()
+}
+
+// the name HELP and TYPE are written for
+func (s Sample) family() string {
+	if s.Type != TypeHistogram {
+		return s.Name
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if strings.HasSuffix(s.Name, suffix) {
+			return strings.TrimSuffix(s.Name, suffix)
+		}
+	}
+	return s.Name
+}
+
+// series name with labels, e.g. sigint_frames_total{type="Data"}
+func (s Sample) series() string {
+	if len(s.Labels) == 0 {
+		return s.Name
+	}
+	var b strings.Builder
+	b.WriteString(s.Name)
+	b.WriteByte('{')
+	for i := 0; i+1 < len(s.Labels); i += 2 {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", s.Labels[i], escape(s.Labels[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// in the Prometheus text format, version 0.0.4
+func Write(w io.Writer, samples []Sample) error {
+	// series of a family must be together
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].family() < samples[j].family()
+	})
+	bw := bufio.NewWriter(w)
+	last := ""
+	for _, s := range samples {
+		if family := s.family(); family != last {
+			if s.Help != "" {
+				fmt.Fprintf(bw, "# HELP %s %s\n", family, strings.Replace(s.Help, "\n", " ", -1))
+			}
+			fmt.Fprintf(bw, "# TYPE %s %s\n", family, s.Type)
+			last = family
+		}
+		fmt.Fprintf(bw, "%s %s\n", s.series(), formatValue(s.Value))
+	}
+	return bw.Flush()
+}
+
+func gather(cs []Collector) (samples []Sample) {
+	for _, c := range cs {
+		samples = append(samples, c.Metrics()...)
+	}
+	return
+}
+
+// serves the metrics of <cs> for Prometheus to scrape
+func Handler(cs ...Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := Write(w, gather(cs)); err != nil {
+			log.Printf("Error: writing metrics: %v", err)
+		}
+	})
+}
+
+// what Publish()ed names show
+var (
+	published    = map[string][]Collector{}
+	publishedMtx sync.Mutex
+)
+
+// the metrics of <cs> as expvar <name>, a map of series to values.
+// Publishing a name again replaces its collectors.
+func Publish(name string, cs ...Collector) {
+	publishedMtx.Lock()
+	defer publishedMtx.Unlock()
+	_, ok := published[name]
+	published[name] = cs
+	if ok {
+		return
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		publishedMtx.Lock()
+		cs := published[name]
+		publishedMtx.Unlock()
+		vars := map[string]float64{}
+		for _, s := range gather(cs) {
+			vars[s.series()] = s.Value
+		}
+		return vars
+	}))
+}
+
+// Serve /metrics and expvar's /debug/vars on <addr>, until ctx is Done().
+func Serve(ctx context.Context, addr string, cs ...Collector) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return serve(ctx, lis, cs...)
+}
+
+func serve(ctx context.Context, lis net.Listener, cs ...Collector) error {
+	Publish("sigint", cs...)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(cs...))
+	mux.Handle("/debug/vars", expvar.Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	log.Printf("serving metrics on %v", lis.Addr())
+	err := srv.Serve(lis)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Counts observations into buckets, e.g. of latencies in seconds. Safe for
+// concurrent use.
+type Histogram struct {
+	mtx    sync.Mutex
+	bounds []float64
+	// per bucket, the last one is +Inf
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// <bounds> are the upper bounds of the buckets, ascending
+func NewHistogram(bounds ...float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mtx.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mtx.Unlock()
+}
+
+// as the series of the histogram <name>
+func (h *Histogram) Samples(name, help string, labels ...string) []Sample {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	samples := make([]Sample, 0, len(h.counts)+2)
+	var cumulative uint64
+	for i, n := range h.counts {
+		cumulative += n
+		le := math.Inf(1)
+		if i < len(h.bounds) {
+			le = h.bounds[i]
+		}
+		samples = append(samples, Sample{Name: name + "_bucket", Help: help, Type: TypeHistogram,
+			Labels: append(append([]string(nil), labels...), "le", formatValue(le)), Value: float64(cumulative)})
+	}
+	return append(samples,
+		Sample{Name: name + "_sum", Help: help, Type: TypeHistogram, Labels: labels, Value: h.sum},
+		Sample{Name: name + "_count", Help: help, Type: TypeHistogram, Labels: labels, Value: float64(h.count)})
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	h := NewHistogram(.1, 1)
+	h.Observe(.05)
+	h.Observe(.5)
+	h.Observe(5)
+	samples := append([]Sample{
+		Counter("sigint_frames_total", "frames", 3, "type", "Data"),
+		Gauge("sigint_queued", "", 1.5),
+		Counter("sigint_frames_total", "frames", 1, "type", `Mgmt"Probe\Req`),
+	}, h.Samples("sigint_store_batch_seconds", "batch\nlatency")...)
+	var buf bytes.Buffer
+	if err := Write(&buf, samples); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP sigint_frames_total frames
+# TYPE sigint_frames_total counter
+sigint_frames_total{type="Data"} 3
+sigint_frames_total{type="Mgmt\"Probe\\Req"} 1
+# TYPE sigint_queued gauge
+sigint_queued 1.5
+# HELP sigint_store_batch_seconds batch latency
+# TYPE sigint_store_batch_seconds histogram
+sigint_store_batch_seconds_bucket{le="0.1"} 1
+sigint_store_batch_seconds_bucket{le="1"} 2
+sigint_store_batch_seconds_bucket{le="+Inf"} 3
+sigint_store_batch_seconds_sum 5.55
+sigint_store_batch_seconds_count 3
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestServe(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	n := 0.0
+	go func() {
+		served <- serve(ctx, lis, Func(func() []Sample {
+			n++
+			return []Sample{Counter("sigint_scrapes_total", "", n)}
+		}))
+	}()
+	get := func(path string) string {
+		resp, err := http.Get("http://" + lis.Addr().String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %s, %v", path, resp.Status, err)
+		}
+		return string(body)
+	}
+	if body := get("/metrics"); !strings.Contains(body, "sigint_scrapes_total 1\n") {
+		t.Errorf("/metrics: %s", body)
+	}
+	var vars struct {
+		Sigint map[string]float64 `json:"sigint"`
+	}
+	if err = json.Unmarshal([]byte(get("/debug/vars")), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Sigint["sigint_scrapes_total"] != 2 {
+		t.Errorf("expvar: %v", vars.Sigint)
+	}
+	cancel()
+	if err = <-served; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,60 @@
+package wifi
+
+import (
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	"net"
+)
+
+// from linux/nl80211.h
+const (
+	nl80211CmdSetWiphy       = 2
+	nl80211AttrIfindex       = 3
+	nl80211AttrWiphyFreq     = 38
+	nl80211AttrChannelWidth  = 159
+	nl80211AttrCenterFreq1   = 160
+	nl80211GenlFamilyName    = "nl80211"
+	nl80211GenlFamilyVersion = 0
+)
+
+// Sets channels via generic netlink, works for all bands and widths the
+// driver supports. Needs CAP_NET_ADMIN.
+type NL80211 struct {
+	family uint16
+}
+
+func NewNL80211() (*NL80211, error) {
+	fam, err := netlink.GenlFamilyGet(nl80211GenlFamilyName)
+	if err != nil {
+		return nil, err
+	}
+	return &NL80211{family: fam.ID}, nil
+}
+
+// like `iw dev <ifname> set freq <freq> <width> <center>`
+func (n *NL80211) SetChannel(ifname string, spec ChannelSpec) error {
+	freq := spec.Freq()
+	center, err := spec.CenterFreq()
+	if err != nil {
+		return err
+	}
+	ifi, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return err
+	}
+	req := nl.NewNetlinkRequest(int(n.family), unix.NLM_F_ACK)
+	req.AddData(&nl.Genlmsg{Command: nl80211CmdSetWiphy, Version: nl80211GenlFamilyVersion})
+	req.AddData(nl.NewRtAttr(nl80211AttrIfindex, nl.Uint32Attr(uint32(ifi.Index))))
+	req.AddData(nl.NewRtAttr(nl80211AttrWiphyFreq, nl.Uint32Attr(uint32(freq))))
+	req.AddData(nl.NewRtAttr(nl80211AttrChannelWidth, nl.Uint32Attr(uint32(spec.Width))))
+	if spec.Width.MHz() > 20 {
+		req.AddData(nl.NewRtAttr(nl80211AttrCenterFreq1, nl.Uint32Attr(uint32(center))))
+	}
+	_, err = req.Execute(unix.NETLINK_GENERIC, 0)
+	if err != nil {
+		return fmt.Errorf("nl80211: %v", err)
+	}
+	return nil
+}
@@ -2,13 +2,24 @@ package wifi
 
 import (
 	"fmt"
+	"github.com/google/gopacket/layers"
+	"github.com/tinygoprogs/sigint/wifi/metrics"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
 )
 
 type PacketStats struct {
 	stats map[string]int
-	mtx   sync.RWMutex
+	// decoded frames by 802.11 type and radiotap frequency
+	types map[layers.Dot11Type]int
+	freqs map[int]int
+	// by ShouldIgnore reason
+	ignored map[string]int
+	mtx     sync.RWMutex
+	// per channel statistics of the channel hopping, may be nil
+	channels func() []ChannelStat
 }
 
 func (s *PacketStats) inc(which string) {
@@ -17,6 +28,26 @@ func (s *PacketStats) inc(which string) {
 	s.mtx.Unlock()
 }
 
+func (s *PacketStats) frame(typ layers.Dot11Type, freq int) {
+	s.mtx.Lock()
+	s.types[typ]++
+	s.freqs[freq]++
+	s.mtx.Unlock()
+}
+
+func (s *PacketStats) ignore(reason string) {
+	s.mtx.Lock()
+	s.stats["filtered"]++
+	s.ignored[reason]++
+	s.mtx.Unlock()
+}
+
+func (s *PacketStats) get(which string) int {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.stats[which]
+}
+
 func (s *PacketStats) log() {
 	s.mtx.RLock()
 	log.Print(s.String())
@@ -24,13 +55,75 @@ func (s *PacketStats) log() {
 }
 
 func (s *PacketStats) String() string {
-	return fmt.Sprintf("PacketStats[%v]", s.stats)
+	if s.channels == nil {
+		return fmt.Sprintf("PacketStats[%v, types:%v, ignored:%v]", s.stats, s.types, s.ignored)
+	}
+	return fmt.Sprintf("PacketStats[%v, types:%v, ignored:%v, channels:%v]", s.stats, s.types, s.ignored, s.channels())
 }
 
 func NewPacketStats(values ...string) *PacketStats {
-	s := PacketStats{stats: make(map[string]int, len(values))}
+	s := PacketStats{
+		stats:   make(map[string]int, len(values)),
+		types:   map[layers.Dot11Type]int{},
+		freqs:   map[int]int{},
+		ignored: map[string]int{},
+	}
 	for _, val := range values {
 		s.stats[val] = 0
 	}
 	return &s
 }
+
+// e.g. "dropped oldest" as sigint_packets_dropped_oldest_total, "total" as
+// sigint_packets_total
+func (s *PacketStats) metrics() (samples []metrics.Sample) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	for which, n := range s.stats {
+		name := "sigint_packets_total"
+		if which != "total" {
+			name = "sigint_packets_" + strings.Replace(which, " ", "_", -1) + "_total"
+		}
+		samples = append(samples, metrics.Counter(name, fmt.Sprintf("packets counted as %s", which), float64(n)))
+	}
+	for typ, n := range s.types {
+		samples = append(samples, metrics.Counter("sigint_frames_total",
+			"decoded frames by 802.11 type", float64(n), "type", typ.String()))
+	}
+	for freq, n := range s.freqs {
+		samples = append(samples, metrics.Counter("sigint_channel_frames_total",
+			"decoded frames by the frequency radiotap reports, in MHz", float64(n), "frequency", strconv.Itoa(freq)))
+	}
+	for reason, n := range s.ignored {
+		samples = append(samples, metrics.Counter("sigint_ignored_total",
+			"addresses skipped, by IgnoreEUIs reason", float64(n), "reason", reason))
+	}
+	return
+}
+
+// PacketStats, the channel hopping and what the kernel dropped, see
+// metrics.Serve
+func (w *Wifi) Metrics() []metrics.Sample {
+	samples := w.stats.metrics()
+	samples = append(samples, metrics.Counter("sigint_hop_errors_total",
+		"channel switches that failed", float64(w.hopper.Errors())))
+	if w.Interface != "" {
+		for _, cs := range w.hopper.Strategy().Stats() {
+			ch := []string{"band", cs.Band.String(), "channel", strconv.Itoa(cs.Channel)}
+			samples = append(samples,
+				metrics.Counter("sigint_hop_visits_total", "times the hopper tuned to a channel", float64(cs.Visits), ch...),
+				metrics.Counter("sigint_hop_frames_total", "interesting frames per hop plan channel", float64(cs.Frames), ch...),
+				metrics.Gauge("sigint_hop_frame_rate", "interesting frames per second on a channel, smoothed", cs.Rate, ch...))
+		}
+	}
+	ks, err := w.KernelStats()
+	if err != nil {
+		log.Printf("Error: %v", err)
+	} else if ks != nil {
+		samples = append(samples,
+			metrics.Counter("sigint_kernel_received_total", "packets that passed the BPF program", float64(ks.PacketsReceived)),
+			metrics.Counter("sigint_kernel_dropped_total", "packets the kernel dropped, the buffer was full", float64(ks.PacketsDropped)),
+			metrics.Counter("sigint_kernel_ifdropped_total", "packets the interface dropped", float64(ks.PacketsIfDropped)))
+	}
+	return samples
+}
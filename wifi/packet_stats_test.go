@@ -0,0 +1,35 @@
+package wifi
+
+import (
+	"bytes"
+	"context"
+	"github.com/tinygoprogs/sigint/wifi/metrics"
+	"strings"
+	"testing"
+)
+
+func TestWifiMetrics(t *testing.T) {
+	frames := append(mixedFrames(t), probeRequest(t, "00:00:00:00:00:00", 2412, -60).Data())
+	src := &replaySource{frames: frames, n: 2}
+	w := NewWifi(WifiConfig{Source: src, DisableLinker: true})
+	for range w.Start(context.Background()) {
+	}
+	var buf bytes.Buffer
+	if err := metrics.Write(&buf, w.Metrics()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"sigint_packets_total 20\n",
+		"sigint_packets_interesting_total 10\n",
+		"sigint_packets_dropped_oldest_total 0\n",
+		`sigint_frames_total{type="MgmtProbeReq"} 6` + "\n",
+		`sigint_frames_total{type="MgmtBeacon"} 2` + "\n",
+		`sigint_channel_frames_total{frequency="5180"} 2` + "\n",
+		`sigint_ignored_total{reason="zero"} 2` + "\n",
+		"sigint_hop_errors_total 0\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("no %q in\n%s", want, buf.String())
+		}
+	}
+}
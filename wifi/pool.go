@@ -0,0 +1,182 @@
+package wifi
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"strings"
+	"sync"
+)
+
+const QueueLenDefault = 256
+const SampleEveryDefault = 10
+
+// what the reader does when the queue of a worker is full
+type OverflowPolicy int
+
+const (
+	// wait for the worker, the source (e.g. the kernel) may drop then
+	OverflowBlock OverflowPolicy = iota
+	// make room by dropping the oldest packet in the queue
+	OverflowDropOldest
+	// drop the packet just read
+	OverflowDropNewest
+	// drop, but wait for the worker with every SampleEvery'th packet
+	OverflowSample
+)
+
+var overflowNames = []string{"block", "drop-oldest", "drop-newest", "sample"}
+
+// the PacketStats counter of each policy, all but "blocked" are also
+// counted as "dropped"
+var overflowStats = []string{"blocked", "dropped oldest", "dropped newest", "dropped sampled"}
+
+func (p OverflowPolicy) String() string {
+	if p < 0 || int(p) >= len(overflowNames) {
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+	return overflowNames[p]
+}
+
+// one of "block", "drop-oldest", "drop-newest" and "sample"
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for i, name := range overflowNames {
+		if strings.TrimSpace(s) == name {
+			return OverflowPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy '%s'", s)
+}
+
+// a copy of a packet, for the workers
+type packetBuf struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+var packetBufs = sync.Pool{New: func() interface{} {
+	return &packetBuf{data: make([]byte, 0, 2048)}
+}}
+
+// Hands packets from the reader to the workers. Packets of a device always
+// go to the same worker, which keeps them in capture order.
+type pool struct {
+	queues    []chan *packetBuf
+	policy    OverflowPolicy
+	every     int
+	overflows int
+	stats     *PacketStats
+}
+
+func newPool(w *Wifi) *pool {
+	p := &pool{
+		queues: make([]chan *packetBuf, w.Workers),
+		policy: w.Overflow,
+		every:  w.SampleEvery,
+		stats:  w.stats,
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan *packetBuf, w.QueueLen)
+	}
+	return p
+}
+
+// copy <data> and queue it, applying the policy if the worker is behind.
+// Not safe for concurrent use, there is one reader.
+func (p *pool) put(ctx context.Context, data []byte, ci gopacket.CaptureInfo) {
+	buf := packetBufs.Get().(*packetBuf)
+	buf.data = append(buf.data[:0], data...)
+	buf.ci = ci
+	q := p.queues[shard(data, len(p.queues))]
+	select {
+	case q <- buf:
+		return
+	default:
+	}
+	switch p.policy {
+	case OverflowDropOldest:
+		// the worker may take it meanwhile
+		for {
+			select {
+			case old := <-q:
+				p.drop(old)
+			default:
+			}
+			select {
+			case q <- buf:
+				return
+			default:
+			}
+		}
+	case OverflowDropNewest:
+		p.drop(buf)
+		return
+	case OverflowSample:
+		p.overflows++
+		if p.overflows%p.every != 0 {
+			p.drop(buf)
+			return
+		}
+	default:
+		p.stats.inc(overflowStats[OverflowBlock])
+	}
+	select {
+	case q <- buf:
+	case <-ctx.Done():
+		packetBufs.Put(buf)
+	}
+}
+
+func (p *pool) drop(buf *packetBuf) {
+	p.stats.inc("dropped")
+	p.stats.inc(overflowStats[p.policy])
+	packetBufs.Put(buf)
+}
+
+// no more put()s, the workers finish what is queued
+func (p *pool) close() {
+	for _, q := range p.queues {
+		close(q)
+	}
+}
+
+// The worker for the device in a radiotap + 802.11 frame, by the address
+// ToDevice will pick. Frames that are too short all go to the first one.
+func shard(data []byte, n int) int {
+	if n == 1 || len(data) < 4 {
+		return 0
+	}
+	off := int(binary.LittleEndian.Uint16(data[2:4]))
+	if len(data) < off+dot11Address2+6 {
+		return 0
+	}
+	addr := data[off+dot11Address2 : off+dot11Address2+6]
+	if data[off+1]&fcFlagsDS == fcFromDS {
+		addr = data[off+dot11Address1 : off+dot11Address1+6]
+	}
+	// FNV-1a
+	h := uint32(2166136261)
+	for _, b := range addr {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return int(h % uint32(n))
+}
+
+// one of w.Workers, until <q> is closed or ctx is Done()
+func (w *Wifi) work(ctx context.Context, q <-chan *packetBuf) {
+	d := newDecoder()
+	for {
+		select {
+		case buf, ok := <-q:
+			if !ok {
+				return
+			}
+			w.process(ctx, d, buf.data, buf.ci)
+			packetBufs.Put(buf)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package wifi
+
+import (
+	"context"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"testing"
+	"time"
+)
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, p := range []OverflowPolicy{OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowSample} {
+		got, err := ParseOverflowPolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("%v: got %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParseOverflowPolicy("drop"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
+
+func TestShard(t *testing.T) {
+	sta, ap := mac(t, "00:1b:63:00:00:01"), mac(t, "00:1b:63:00:00:02")
+	probe := probeRequest(t, sta.String(), 2412, -60).Data()
+	up := frame(t, &layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsToDS, Address1: ap, Address2: sta, Address3: ap})
+	down := frame(t, &layers.Dot11{Type: layers.Dot11TypeData, Flags: layers.Dot11FlagsFromDS, Address1: sta, Address2: ap, Address3: ap})
+	for n := 1; n < 16; n++ {
+		if shard(probe, n) != shard(up, n) || shard(up, n) != shard(down, n) {
+			t.Errorf("%d workers: frames of one station on %d, %d, %d", n, shard(probe, n), shard(up, n), shard(down, n))
+		}
+	}
+	if shard([]byte{0, 0, 8, 0}, 4) != 0 || shard(nil, 4) != 0 {
+		t.Error("truncated frame not on the first worker")
+	}
+}
+
+// a pool with a single queue of two and no workers, after putting 5 packets
+func overflow(t *testing.T, policy OverflowPolicy) (*pool, []byte) {
+	w := NewWifi(WifiConfig{Workers: 1, QueueLen: 2, Overflow: policy, SampleEvery: 2})
+	p := newPool(w)
+	ctx, cancel := context.WithCancel(context.Background())
+	// don't wait forever
+	cancel()
+	for i := 0; i < 5; i++ {
+		p.put(ctx, []byte{byte(i)}, gopacket.CaptureInfo{})
+	}
+	p.close()
+	var queued []byte
+	for buf := range p.queues[0] {
+		queued = append(queued, buf.data[0])
+	}
+	return p, queued
+}
+
+func TestPoolOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy  OverflowPolicy
+		queued  string
+		counter string
+		count   int
+	}{
+		{OverflowBlock, "\x00\x01", "blocked", 3},
+		{OverflowDropOldest, "\x03\x04", "dropped oldest", 3},
+		{OverflowDropNewest, "\x00\x01", "dropped newest", 3},
+		// the second overflow waits, but ctx is done
+		{OverflowSample, "\x00\x01", "dropped sampled", 2},
+	} {
+		p, queued := overflow(t, tc.policy)
+		if string(queued) != tc.queued {
+			t.Errorf("%v: queued %x", tc.policy, queued)
+		}
+		if n := p.stats.get(tc.counter); n != tc.count {
+			t.Errorf("%v: %s=%d, want %d", tc.policy, tc.counter, n, tc.count)
+		}
+		dropped := tc.count
+		if tc.policy == OverflowBlock {
+			dropped = 0
+		}
+		if n := p.stats.get("dropped"); n != dropped {
+			t.Errorf("%v: dropped=%d, want %d", tc.policy, n, dropped)
+		}
+	}
+}
+
+func TestListenKeepsOrderPerMAC(t *testing.T) {
+	var frames [][]byte
+	for _, sa := range []string{"00:1b:63:00:00:01", "00:1b:63:00:00:02", "00:1b:63:00:00:03", "02:00:00:00:00:04", "02:00:00:00:00:05"} {
+		frames = append(frames, probeRequest(t, sa, 2412, -60).Data())
+	}
+	for _, policy := range []OverflowPolicy{OverflowBlock, OverflowDropOldest, OverflowDropNewest, OverflowSample} {
+		src := &replaySource{frames: frames, n: 200}
+		w := NewWifi(WifiConfig{Source: src, Workers: 3, QueueLen: 4, DevChannelWidth: 1, Overflow: policy, DisableLinker: true})
+		last := map[string]uint64{}
+		ndevs := 0
+		for dev := range w.Start(context.Background()) {
+			// a slow consumer
+			if ndevs%50 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			ndevs++
+			stamp := dev.DataPoints[0].TimeStamp
+			if stamp <= last[dev.MAC] {
+				t.Fatalf("%v: %s out of order", policy, dev.MAC)
+			}
+			last[dev.MAC] = stamp
+		}
+		if ndevs+w.stats.get("dropped") != len(frames)*src.n {
+			t.Errorf("%v: %d devices, %v", policy, ndevs, w.stats)
+		}
+		if policy == OverflowBlock && w.stats.get("dropped") != 0 {
+			t.Errorf("%v: dropped packets, %v", policy, w.stats)
+		}
+	}
+}
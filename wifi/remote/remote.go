@@ -0,0 +1,205 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"github.com/tinygoprogs/sigint/wifi"
+	"log"
+	"time"
+)
+
+const BatchSizeDefault = 64
+const BatchDelayDefault = time.Second * 10
+const SpoolDirDefault = "sigint-wifi-spool"
+const SendTimeoutDefault = time.Second * 30
+const BackoffMinDefault = time.Second
+const BackoffMaxDefault = time.Minute * 5
+
+// can be passed empty, sane defaults will be choosen
+type Config struct {
+	// send once this many devices are collected..
+	BatchSize int
+	// ..or the first one waited this long
+	BatchDelay time.Duration
+	// unsent batches are kept here, across restarts
+	SpoolDir    string
+	SendTimeout time.Duration
+	// wait between retries, doubling from min to max
+	BackoffMin time.Duration
+	BackoffMax time.Duration
+}
+
+// Pushes devices to a remote Collector, for phones with flaky connectivity.
+//
+// Devices are sent in batches, batches that fail (or are not fully acked) go
+// to an on-disk Spool, which is drained oldest first once the Collector is
+// back. Batches are resent as a whole, so the Collector may see a device
+// twice. An ack means the Collector queued a batch, not that it is stored,
+// see local.Store.
+type Client struct {
+	Config
+	collector wifi.CollectorClient
+	spool     *Spool
+	batches   chan *wifi.Devices
+	done      chan bool
+}
+
+func NewClient(collector wifi.CollectorClient, conf Config) (*Client, error) {
+	if conf.BatchSize == 0 {
+		conf.BatchSize = BatchSizeDefault
+	}
+	if conf.BatchDelay == 0 {
+		conf.BatchDelay = BatchDelayDefault
+	}
+	if conf.SpoolDir == "" {
+		conf.SpoolDir = SpoolDirDefault
+	}
+	if conf.SendTimeout == 0 {
+		conf.SendTimeout = SendTimeoutDefault
+	}
+	if conf.BackoffMin == 0 {
+		conf.BackoffMin = BackoffMinDefault
+	}
+	if conf.BackoffMax == 0 {
+		conf.BackoffMax = BackoffMaxDefault
+	}
+	spool, err := NewSpool(conf.SpoolDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		Config:    conf,
+		collector: collector,
+		spool:     spool,
+		batches:   make(chan *wifi.Devices, 4),
+		done:      make(chan bool, 1),
+	}, nil
+}
+
+// Batch and send <devices> until the channel is closed (see Wifi.Start).
+// Sending stops with <-ctx.Done(), everything unsent is spooled then.
+func (c *Client) Run(ctx context.Context, devices <-chan *wifi.Device) {
+	go c.send(ctx)
+	var batch []*wifi.Device
+	var timeout <-chan time.Time
+	flush := func() {
+		if len(batch) != 0 {
+			c.batches <- &wifi.Devices{Devices: batch}
+		}
+		batch, timeout = nil, nil
+	}
+	for {
+		select {
+		case dev, ok := <-devices:
+			if !ok {
+				flush()
+				close(c.batches)
+				return
+			}
+			if len(batch) == 0 {
+				timeout = time.After(c.BatchDelay)
+			}
+			batch = append(batch, dev)
+			if len(batch) >= c.BatchSize {
+				flush()
+			}
+		case <-timeout:
+			flush()
+		}
+	}
+}
+
+// wait until everything is sent or spooled, after Run() returned
+func (c *Client) Wait() {
+	<-c.done
+}
+
+// number of batches waiting for the Collector
+func (c *Client) Spooled() int {
+	return c.spool.Len()
+}
+
+// the only one talking to the Collector, so batches stay in order
+func (c *Client) send(ctx context.Context) {
+	defer func() { c.done <- true }()
+	backoff := c.BackoffMin
+	// nil: spool is empty, nothing to retry
+	var retry <-chan time.Time
+	if c.spool.Len() != 0 {
+		retry = time.After(0)
+	}
+	stopping := ctx.Done()
+	for {
+		select {
+		case devs, ok := <-c.batches:
+			if !ok {
+				return
+			}
+			if retry == nil && ctx.Err() == nil {
+				err := c.push(ctx, devs)
+				if err == nil {
+					continue
+				}
+				log.Printf("sending %d devices failed, spooling: %v", len(devs.Devices), err)
+				retry = time.After(backoff)
+			}
+			if err := c.spool.Put(devs); err != nil {
+				log.Printf("failed to spool: %v, %v, data is lost now!", devs, err)
+			}
+		case <-retry:
+			if c.drain(ctx) {
+				backoff, retry = c.BackoffMin, nil
+				continue
+			}
+			if backoff *= 2; backoff > c.BackoffMax {
+				backoff = c.BackoffMax
+			}
+			retry = time.After(backoff)
+		case <-stopping:
+			// spool the rest, until Run closes c.batches
+			stopping, retry = nil, nil
+		}
+	}
+}
+
+// send spooled batches, false if the Collector is still unreachable
+func (c *Client) drain(ctx context.Context) bool {
+	for ctx.Err() == nil {
+		name, devs, err := c.spool.Oldest()
+		if err != nil {
+			log.Printf("Error: %v", err)
+			return false
+		}
+		if devs == nil {
+			return true
+		}
+		if err = c.push(ctx, devs); err != nil {
+			log.Printf("resending %s failed: %v", name, err)
+			return false
+		}
+		if err = c.spool.Remove(name); err != nil {
+			log.Printf("Error: %v", err)
+			return false
+		}
+	}
+	return false
+}
+
+// send and verify the ack
+func (c *Client) push(ctx context.Context, devs *wifi.Devices) error {
+	ctx, cancel := context.WithTimeout(ctx, c.SendTimeout)
+	defer cancel()
+	ack, err := c.collector.NewDevices(ctx, devs)
+	if err != nil {
+		return err
+	}
+	var ndps int32
+	for _, dev := range devs.Devices {
+		ndps += int32(len(dev.DataPoints))
+	}
+	if ack.NDevices != int32(len(devs.Devices)) || ack.NDataPoints != ndps {
+		return fmt.Errorf("ack mismatch: sent %d devices, %d datapoints, got %v",
+			len(devs.Devices), ndps, ack)
+	}
+	return nil
+}
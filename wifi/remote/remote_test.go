@@ -0,0 +1,171 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/tinygoprogs/sigint/wifi"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	flag.Parse()
+	log.SetFlags(log.Lshortfile | log.Lmicroseconds)
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+}
+
+type fakeCollector struct {
+	wifi.CollectorClient
+	mtx sync.Mutex
+	// fail this many calls, then ack this many wrong
+	fail    int
+	badAcks int
+	got     []string
+}
+
+func (f *fakeCollector) NewDevices(ctx context.Context, devs *wifi.Devices, opts ...grpc.CallOption) (*wifi.Ack, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.fail > 0 {
+		f.fail--
+		return nil, errors.New("unreachable")
+	}
+	ack := &wifi.Ack{NDevices: int32(len(devs.Devices))}
+	if f.badAcks > 0 {
+		f.badAcks--
+		ack.NDevices--
+		return ack, nil
+	}
+	for _, dev := range devs.Devices {
+		f.got = append(f.got, dev.MAC)
+		ack.NDataPoints += int32(len(dev.DataPoints))
+	}
+	return ack, nil
+}
+
+func (f *fakeCollector) received() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]string(nil), f.got...)
+}
+
+func device(i int) *wifi.Device {
+	return &wifi.Device{
+		MAC:        fmt.Sprintf("00:00:00:00:00:%02x", i),
+		DataPoints: []*wifi.DataPoint{&wifi.DataPoint{Signal: uint32(i)}},
+	}
+}
+
+func testConfig(t *testing.T) Config {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Config{
+		BatchSize:  2,
+		BatchDelay: time.Millisecond * 10,
+		SpoolDir:   dir,
+		BackoffMin: time.Millisecond,
+		BackoffMax: time.Millisecond * 4,
+	}
+}
+
+// wait until <n> devices arrived and nothing is spooled
+func waitFor(t *testing.T, f *fakeCollector, c *Client, n int) []string {
+	for i := 0; i < 500; i++ {
+		if got := f.received(); len(got) == n && c.Spooled() == 0 {
+			return got
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("got %v, %d batches still spooled", f.received(), c.Spooled())
+	return nil
+}
+
+func inOrder(t *testing.T, got []string, from int) {
+	for i, mac := range got {
+		if mac != device(from+i).MAC {
+			t.Errorf("device %d: got %s, expected %s", i, mac, device(from+i).MAC)
+		}
+	}
+}
+
+func TestClientBatches(t *testing.T) {
+	conf := testConfig(t)
+	defer os.RemoveAll(conf.SpoolDir)
+	f := &fakeCollector{}
+	c, err := NewClient(f, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := make(chan *wifi.Device)
+	go c.Run(context.Background(), devices)
+	for i := 0; i < 5; i++ {
+		devices <- device(i)
+	}
+	// the last one is sent after BatchDelay
+	inOrder(t, waitFor(t, f, c, 5), 0)
+	close(devices)
+	c.Wait()
+}
+
+func TestClientSpoolsAndRetries(t *testing.T) {
+	conf := testConfig(t)
+	defer os.RemoveAll(conf.SpoolDir)
+	f := &fakeCollector{fail: 3, badAcks: 1}
+	c, err := NewClient(f, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := make(chan *wifi.Device)
+	go c.Run(context.Background(), devices)
+	for i := 0; i < 6; i++ {
+		devices <- device(i)
+	}
+	inOrder(t, waitFor(t, f, c, 6), 0)
+	close(devices)
+	c.Wait()
+}
+
+func TestClientSpoolsOnShutdown(t *testing.T) {
+	conf := testConfig(t)
+	defer os.RemoveAll(conf.SpoolDir)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c, err := NewClient(&fakeCollector{}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := make(chan *wifi.Device, 3)
+	for i := 0; i < 3; i++ {
+		devices <- device(i)
+	}
+	close(devices)
+	c.Run(ctx, devices)
+	c.Wait()
+	if n := c.Spooled(); n != 2 {
+		t.Fatalf("expected 2 spooled batches, got %d", n)
+	}
+
+	// the next run drains them first
+	f := &fakeCollector{}
+	c, err = NewClient(f, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices = make(chan *wifi.Device)
+	go c.Run(context.Background(), devices)
+	devices <- device(3)
+	inOrder(t, waitFor(t, f, c, 4), 0)
+	close(devices)
+	c.Wait()
+}
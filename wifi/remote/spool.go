@@ -0,0 +1,108 @@
+package remote
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/tinygoprogs/sigint/wifi"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const spoolExt = ".batch"
+
+// A durable FIFO of batches, one proto marshaled wifi.Devices per file, named
+// by the time it was spooled.
+type Spool struct {
+	dir  string
+	mtx  sync.Mutex
+	last int64 // to keep names unique and ordered
+}
+
+// creates <dir> if needed, batches in it from earlier runs are kept
+func NewSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Spool{dir: dir}, nil
+}
+
+func (s *Spool) Put(devs *wifi.Devices) error {
+	data, err := proto.Marshal(devs)
+	if err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	id := time.Now().UnixNano()
+	if id <= s.last {
+		id = s.last + 1
+	}
+	s.last = id
+	s.mtx.Unlock()
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, spoolExt))
+	// a crash must not leave half a batch behind
+	tmp := name + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (s *Spool) names() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), spoolExt) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// the oldest batch, nil if the spool is empty. Unreadable batches are moved
+// aside, so they don't block the queue.
+func (s *Spool) Oldest() (name string, devs *wifi.Devices, err error) {
+	names, err := s.names()
+	if err != nil {
+		return
+	}
+	for _, name = range names {
+		path := filepath.Join(s.dir, name)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", nil, err
+		}
+		devs = &wifi.Devices{}
+		if err = proto.Unmarshal(data, devs); err != nil {
+			log.Printf("spooled batch %s is corrupt, moving it aside: %v", name, err)
+			os.Rename(path, path+".bad")
+			continue
+		}
+		return name, devs, nil
+	}
+	return "", nil, nil
+}
+
+// drop a batch returned by Oldest()
+func (s *Spool) Remove(name string) error {
+	return os.Remove(filepath.Join(s.dir, name))
+}
+
+// number of spooled batches
+func (s *Spool) Len() int {
+	names, err := s.names()
+	if err != nil {
+		log.Printf("Error: %v", err)
+	}
+	return len(names)
+}
@@ -0,0 +1,47 @@
+package remote
+
+import (
+	"github.com/tinygoprogs/sigint/wifi"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, devs, err := s.Oldest(); name != "" || devs != nil || err != nil {
+		t.Errorf("empty spool returned %s, %v, %v", name, devs, err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "00000000000000000000"+spoolExt), []byte("garbage"), 0600)
+	for i := 0; i < 3; i++ {
+		if err = s.Put(&wifi.Devices{Devices: []*wifi.Device{device(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		name, devs, err := s.Oldest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if devs.Devices[0].MAC != device(i).MAC {
+			t.Errorf("batch %d: got %v", i, devs)
+		}
+		s.Remove(name)
+	}
+	if s.Len() != 0 {
+		t.Errorf("%d batches left", s.Len())
+	}
+	// moved aside, not lost
+	if _, err = os.Stat(filepath.Join(dir, "00000000000000000000"+spoolExt+".bad")); err != nil {
+		t.Error(err)
+	}
+}
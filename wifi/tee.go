@@ -0,0 +1,169 @@
+package wifi
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const TeeMaxSizeDefault = 64 << 20
+const TeeMaxAgeDefault = time.Hour
+const TeeKeepDefault = 48
+
+const teePrefix = "sigint-"
+const teeExt = ".pcapng"
+
+// can be passed empty, sane defaults will be choosen
+type TeeConfig struct {
+	// where the files go, the working directory if empty
+	Dir string
+	// start a new file after this many bytes..
+	MaxSize int64
+	// ..or this long
+	MaxAge time.Duration
+	// delete the oldest files, so only this many are left. Negative keeps all
+	Keep int
+	// only packets that made it into a Device, instead of every packet
+	Filtered bool
+}
+
+// Writes raw packets to rotating pcapng files, named by the time they were
+// started, so they can be reprocessed (e.g. by ingest) later.
+type Tee struct {
+	TeeConfig
+	link   layers.LinkType
+	mtx    sync.Mutex
+	f      *os.File
+	w      *pcapgo.NgWriter
+	size   int64
+	opened time.Time
+}
+
+// <link> is the link type of the packets, e.g. layers.LinkTypeIEEE80211Radio
+// to keep radiotap headers
+func NewTee(conf TeeConfig, link layers.LinkType) (*Tee, error) {
+	if conf.MaxSize == 0 {
+		conf.MaxSize = TeeMaxSizeDefault
+	}
+	if conf.MaxAge == 0 {
+		conf.MaxAge = TeeMaxAgeDefault
+	}
+	if conf.Keep == 0 {
+		conf.Keep = TeeKeepDefault
+	}
+	if conf.Dir == "" {
+		conf.Dir = "."
+	}
+	if err := os.MkdirAll(conf.Dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Tee{TeeConfig: conf, link: link}, nil
+}
+
+func (t *Tee) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.w == nil || t.size >= t.MaxSize || time.Since(t.opened) >= t.MaxAge {
+		if err := t.rotate(); err != nil {
+			return err
+		}
+	}
+	// a single interface per file
+	ci.InterfaceIndex = 0
+	ci.CaptureLength = len(data)
+	if ci.Length < ci.CaptureLength {
+		ci.Length = ci.CaptureLength
+	}
+	t.size += int64(len(data)) + 32
+	return t.w.WritePacket(ci, data)
+}
+
+func (t *Tee) Write(p gopacket.Packet) error {
+	return t.WritePacket(p.Metadata().CaptureInfo, p.Data())
+}
+
+// the current file is closed, the next write starts a new one
+func (t *Tee) Close() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.close()
+}
+
+func (t *Tee) close() (err error) {
+	if t.w == nil {
+		return
+	}
+	err = t.w.Flush()
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	t.f, t.w = nil, nil
+	return
+}
+
+func (t *Tee) rotate() (err error) {
+	if t.f != nil {
+		name := t.f.Name()
+		if err = t.close(); err != nil {
+			log.Printf("Error: closing %s: %v", name, err)
+		}
+	}
+	t.opened = time.Now()
+	name := filepath.Join(t.Dir, teePrefix+t.opened.UTC().Format("20060102T150405.000000000Z")+teeExt)
+	t.f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return
+	}
+	t.w, err = pcapgo.NewNgWriter(t.f, t.link)
+	if err != nil {
+		t.f.Close()
+		t.f, t.w = nil, nil
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	t.size = 0
+	t.prune()
+	return nil
+}
+
+// our files in Dir, oldest first
+func (t *Tee) Files() ([]string, error) {
+	entries, err := ioutil.ReadDir(t.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), teePrefix) && strings.HasSuffix(e.Name(), teeExt) {
+			names = append(names, filepath.Join(t.Dir, e.Name()))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// apply Keep, the current file is the newest one
+func (t *Tee) prune() {
+	if t.Keep < 0 {
+		return
+	}
+	names, err := t.Files()
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+	for len(names) > t.Keep {
+		if err = os.Remove(names[0]); err != nil {
+			log.Printf("Error: %v", err)
+		}
+		names = names[1:]
+	}
+}
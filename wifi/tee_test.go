@@ -0,0 +1,195 @@
+package wifi
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// all packets in a pcapng file
+func readTee(t *testing.T, path string) (data [][]byte) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.LinkType() != layers.LinkTypeIEEE80211Radio {
+		t.Errorf("%s: link type %v", path, r.LinkType())
+	}
+	for {
+		d, _, err := r.ReadPacketData()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, d)
+	}
+}
+
+func teeFiles(t *testing.T, tee *Tee) (files []string, npackets int) {
+	files, err := tee.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		npackets += len(readTee(t, f))
+	}
+	return
+}
+
+func TestTeeRotates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := probeRequest(t, "00:1b:63:00:00:01", 2412, -60)
+	// three packets per file
+	tee, err := NewTee(TeeConfig{Dir: dir, MaxSize: int64(len(p.Data())+32) * 3, Keep: 2}, layers.LinkTypeIEEE80211Radio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Metadata().Timestamp = time.Now()
+	for i := 0; i < 10; i++ {
+		if err = tee.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tee.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, n := teeFiles(t, tee)
+	// 3+3+3+1, the first two are gone
+	if len(files) != 2 || n != 4 {
+		t.Errorf("%d packets in %v", n, files)
+	}
+	last := readTee(t, files[1])
+	if len(last) != 1 || !bytes.Equal(last[0], p.Data()) {
+		t.Errorf("radiotap and frame not kept: %x", last)
+	}
+
+	tee.MaxSize, tee.MaxAge = 1<<20, time.Millisecond
+	tee.Write(p)
+	time.Sleep(2 * time.Millisecond)
+	tee.Write(p)
+	tee.Close()
+	if files, _ = tee.Files(); len(files) != 2 {
+		t.Errorf("no rotation by age: %v", files)
+	}
+}
+
+// a pcap with three probe requests and a frame without 802.11
+func teeSource(t *testing.T) PacketSource {
+	var buf bytes.Buffer
+	w := pcapgo.NewWriterNanos(&buf)
+	w.WriteFileHeader(0xffff, layers.LinkTypeIEEE80211Radio)
+	stamp := time.Now()
+	for _, data := range [][]byte{
+		probeRequest(t, "00:1b:63:00:00:01", 2412, -60).Data(),
+		{0, 0, 8, 0, 0, 0, 0, 0},
+		probeRequest(t, "00:1b:63:00:00:02", 2412, -60).Data(),
+		probeRequest(t, "00:1b:63:00:00:03", 2412, -60).Data(),
+	} {
+		ci := gopacket.CaptureInfo{Timestamp: stamp, CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := pcapgo.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestListenTee(t *testing.T) {
+	for _, filtered := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "tee")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		conf := &TeeConfig{Dir: dir, Filtered: filtered}
+		w := NewWifi(WifiConfig{Source: teeSource(t), Block: true, Tee: conf})
+		ndevs := 0
+		for range w.Start(context.Background()) {
+			ndevs++
+		}
+		tee, _ := NewTee(*conf, 0)
+		files, n := teeFiles(t, tee)
+		want := 4
+		if filtered {
+			want = 3
+		}
+		if ndevs != 3 || len(files) != 1 || n != want {
+			t.Errorf("filtered=%v: %d devices, %d packets in %v", filtered, ndevs, n, files)
+		}
+	}
+}
+
+// the reader tees, so workers neither reorder nor lose packets in the files
+func TestListenTeeWorkers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var frames [][]byte
+	for _, sa := range []string{"00:1b:63:00:00:01", "00:1b:63:00:00:02", "00:1b:63:00:00:03"} {
+		frames = append(frames, probeRequest(t, sa, 2412, -60).Data())
+	}
+	src := &replaySource{frames: frames, n: 100}
+	conf := &TeeConfig{Dir: dir}
+	w := NewWifi(WifiConfig{Source: src, Workers: 3, QueueLen: 2, DevChannelWidth: 1,
+		Overflow: OverflowDropNewest, DisableLinker: true, Tee: conf})
+	for range w.Start(context.Background()) {
+		// a slow consumer, workers drop
+		time.Sleep(time.Microsecond * 100)
+	}
+	tee, _ := NewTee(*conf, 0)
+	files, err := tee.Files()
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got %v, %v", files, err)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last time.Time
+	n := 0
+	for {
+		_, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ci.Timestamp.After(last) {
+			t.Fatalf("packet %d out of order", n)
+		}
+		last = ci.Timestamp
+		n++
+	}
+	if n != len(frames)*src.n {
+		t.Errorf("%d of %d packets teed, %v", n, len(frames)*src.n, w.stats)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/tinygoprogs/sigint/wifi/local"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	FListen  = flag.String("listen", ":50051", "address to serve the Collector on")
+	FDbname  = flag.String("dbname", local.FileDefault, "name of the sqlite db")
+	FDriver  = flag.String("driver", local.DriverDefault, "database, 'sqlite3' or 'postgres'")
+	FDSN     = flag.String("dsn", "", "postgres connection string, for -driver postgres")
+	FCert    = flag.String("cert", "", "TLS certificate, plaintext if empty")
+	FKey     = flag.String("key", "", "TLS key for -cert")
+	FMetrics = flag.String("metrics", "", "serve Prometheus /metrics and expvar on this address, e.g. ':9100'")
+)
+
+func init() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
+}
+
+func main() {
+	var opts []grpc.ServerOption
+	if *FCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(*FCert, *FKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	lis, err := net.Listen("tcp", *FListen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+		<-ch
+		cancel()
+	}()
+
+	err = local.Serve(ctx, lis, &local.LocalConfig{
+		Driver:  *FDriver,
+		File:    *FDbname,
+		DSN:     *FDSN,
+		Metrics: *FMetrics,
+	}, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/tinygoprogs/sigint/wifi"
+	"github.com/tinygoprogs/sigint/wifi/export"
+	"github.com/tinygoprogs/sigint/wifi/local"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	FDbname    = flag.String("dbname", local.FileDefault, "name of the sqlite db")
+	FDriver    = flag.String("driver", local.DriverDefault, "database, 'sqlite3' or 'postgres'")
+	FDSN       = flag.String("dsn", "", "postgres connection string, for -driver postgres")
+	FOut       = flag.String("o", "", "output file, stdout if empty")
+	FFormat    = flag.String("format", "", fmt.Sprintf("one of %v, guessed from -o by default", export.Formats))
+	FMAC       = flag.String("mac", "", "only this device")
+	FFrom      = flag.String("from", "", "only sightings since, RFC3339, e.g. 2020-03-01T20:00:00Z")
+	FTo        = flag.String("to", "", "only sightings before, RFC3339")
+	FMinSignal = flag.Int("min-signal", 0, "only sightings at least this strong, in dBm, e.g. -70")
+	FFrequency = flag.Uint("frequency", 0, "only sightings on this frequency, in MHz")
+	FBBox      = flag.String("bbox", "", "only sightings in 'minlon,minlat,maxlon,maxlat'")
+)
+
+func init() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
+}
+
+func query() (q wifi.SightingQuery, err error) {
+	q.MAC = *FMAC
+	q.MinSignal = int32(*FMinSignal)
+	q.Frequency = uint32(*FFrequency)
+	for _, t := range []struct {
+		flag  string
+		stamp *uint64
+	}{{*FFrom, &q.From}, {*FTo, &q.To}} {
+		if t.flag == "" {
+			continue
+		}
+		var ts time.Time
+		if ts, err = time.Parse(time.RFC3339, t.flag); err != nil {
+			return
+		}
+		*t.stamp = uint64(ts.UnixNano())
+	}
+	if *FBBox != "" {
+		a := &wifi.BoundingBox{}
+		_, err = fmt.Sscanf(*FBBox, "%f,%f,%f,%f", &a.MinLon, &a.MinLat, &a.MaxLon, &a.MaxLat)
+		if err != nil {
+			return q, fmt.Errorf("-bbox: %v", err)
+		}
+		q.Area = a
+	}
+	return
+}
+
+func main() {
+	q, err := query()
+	if err != nil {
+		log.Fatal(err)
+	}
+	format := *FFormat
+	if format == "" {
+		format = export.FormatOf(*FOut)
+	}
+	if format == "" {
+		format = "geojson"
+	}
+	// before creating -o
+	if err = export.CheckFormat(format); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st, err := local.NewStore(ctx, &local.LocalConfig{
+		Driver:   *FDriver,
+		File:     *FDbname,
+		DSN:      *FDSN,
+		ReadOnly: true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	// written next to -o and renamed when complete, a failed export must not
+	// leave a truncated file that looks valid
+	out, tmp := os.Stdout, ""
+	if *FOut != "" {
+		tmp = *FOut + ".tmp"
+		if out, err = os.Create(tmp); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fail := func(err error) {
+		if tmp != "" {
+			out.Close()
+			os.Remove(tmp)
+		}
+		log.Fatal(err)
+	}
+	w, err := export.NewWriter(format, out)
+	if err != nil {
+		fail(err)
+	}
+	n, err := export.Export(ctx, st, q, w)
+	cancel()
+	st.Wait()
+	if err != nil {
+		fail(err)
+	}
+	if err = out.Close(); err != nil {
+		fail(err)
+	}
+	if tmp != "" {
+		if err = os.Rename(tmp, *FOut); err != nil {
+			fail(err)
+		}
+	}
+	log.Printf("exported %d sightings", n)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/tinygoprogs/sigint/wifi"
+	"github.com/tinygoprogs/sigint/wifi/ingest"
+	"github.com/tinygoprogs/sigint/wifi/local"
+	"github.com/tinygoprogs/sigint/wifi/location"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	FDbname   = flag.String("dbname", local.FileDefault, "name of the sqlite db")
+	FDriver   = flag.String("driver", local.DriverDefault, "database, 'sqlite3' or 'postgres'")
+	FDSN      = flag.String("dsn", "", "postgres connection string, for -driver postgres")
+	FTrack    = flag.String("track", "", "georeference with a recorded .gpx or .geojson track")
+	FProgress = flag.Duration("progress", ingest.ProgressEveryDefault, "log progress this often")
+	FAggr     = flag.Duration("aggregate", 0, "merge the frames of a device over this long into one datapoint")
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] capture|glob|directory...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
+}
+
+func main() {
+	files, err := ingest.Expand(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(files) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	conf := ingest.Config{
+		Wifi: wifi.WifiConfig{
+			LogAccountingEvery: time.Minute * 1,
+			Aggregate:          *FAggr,
+		},
+		ProgressEvery: *FProgress,
+	}
+	if *FTrack != "" {
+		conf.Wifi.Location, err = location.LoadTrack(*FTrack)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// outlives ctx, so everything ingested is stored
+	store_ctx, stop_store := context.WithCancel(context.Background())
+	st, err := local.NewStore(store_ctx, &local.LocalConfig{
+		Driver: *FDriver,
+		File:   *FDbname,
+		DSN:    *FDSN,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+		<-ch
+		cancel()
+	}()
+
+	_, err = ingest.Ingest(ctx, st, files, conf)
+	stop_store()
+	st.Wait()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
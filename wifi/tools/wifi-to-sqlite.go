@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"github.com/google/gopacket/pcap"
 	"github.com/tinygoprogs/sigint/wifi"
 	"github.com/tinygoprogs/sigint/wifi/local"
+	"github.com/tinygoprogs/sigint/wifi/location"
+	"github.com/tinygoprogs/sigint/wifi/remote"
+	"google.golang.org/grpc/credentials"
 	"log"
 	"os"
 	"os/signal"
@@ -15,7 +20,33 @@ import (
 var (
 	FTimeout = flag.Int("timeout", 0, "collect only for this amount of seconds")
 	FDbname  = flag.String("dbname", "test.db", "name of the sqlite db")
+	FDriver  = flag.String("driver", local.DriverDefault, "database, 'sqlite3' or 'postgres'")
+	FDSN     = flag.String("dsn", "", "postgres connection string, for -driver postgres")
 	FIface   = flag.String("interface", "", "default is to guess")
+	FBands   = flag.String("bands", "2.4", "bands to hop through, e.g. '2.4,5'")
+	FRegdom  = flag.String("regdomain", wifi.RegdomainDefault, "country code, restricts the channels")
+	FDwell   = flag.Duration("dwell", wifi.DwellDefault, "time to stay on each channel")
+	FHop     = flag.String("hop", "", "explicit hop plan, e.g. '1,6,11:2s,36/80', overrides -bands")
+	FAdapt   = flag.Bool("adaptive", false, "stay longer on busy channels")
+	FNoBPF   = flag.Bool("no-bpf", false, "don't drop uninteresting frames in the kernel, implied by -tee without -tee-filtered")
+	FWorkers = flag.Int("workers", 0, "decode packets in this many goroutines, default is one per CPU")
+	FOverflw = flag.String("overflow", "block", "when workers fall behind: 'block', 'drop-oldest', 'drop-newest' or 'sample'")
+	FAggr    = flag.Duration("aggregate", 0, "merge the frames of a device over this long into one datapoint")
+	FMetrics = flag.String("metrics", "", "serve Prometheus /metrics and expvar on this address, e.g. ':9100'")
+	FLocate  = flag.Duration("locate", 0, "poll termux-location this often, 0 disables")
+	FGPSD    = flag.String("gpsd", "", "read fixes from gpsd at host:port, overrides -locate")
+	FNMEA    = flag.String("nmea", "", "read fixes from an NMEA device or log, e.g. /dev/ttyUSB0")
+	FRead    = flag.String("read", "", "replay a capture file instead of listening")
+	FTrack   = flag.String("track", "", "georeference with a recorded .gpx or .geojson track")
+	FRemote  = flag.String("remote", "", "push to the Collector at host:port instead of -dbname")
+	FRemCA   = flag.String("remote-ca", "", "talk TLS to -remote, verifying its certificate with this CA file")
+	FRemTLS  = flag.Bool("remote-tls", false, "talk TLS to -remote, verifying its certificate with the system CAs")
+	FSpool   = flag.String("spool", remote.SpoolDirDefault, "keep batches for -remote here while it is unreachable")
+	FTee     = flag.String("tee", "", "keep raw packets in rotating pcapng files in this directory")
+	FTeeSize = flag.Int64("tee-size", wifi.TeeMaxSizeDefault>>20, "start a new -tee file after this many MiB..")
+	FTeeAge  = flag.Duration("tee-age", wifi.TeeMaxAgeDefault, "..or this long")
+	FTeeKeep = flag.Int("tee-keep", wifi.TeeKeepDefault, "delete the oldest -tee files beyond this many, -1 keeps all")
+	FTeeFilt = flag.Bool("tee-filtered", false, "only keep packets that made it into a device")
 )
 
 func init() {
@@ -26,33 +57,73 @@ func init() {
 func main() {
 	var err error
 	cnf := local.Config{
-		Local: true,
 		LConf: local.LocalConfig{
+			Driver:   *FDriver,
 			File:     *FDbname,
-			Nmaps:    20,
+			DSN:      *FDSN,
 			ChanSize: 0x100,
 		},
+		Remote:  *FRemote,
+		Metrics: *FMetrics,
+		RConf: remote.Config{
+			SpoolDir: *FSpool,
+		},
 		Wifi: wifi.WifiConfig{
 			LogAccountingEvery: time.Minute * 1,
+			DisableBPF:         *FNoBPF,
+			Workers:            *FWorkers,
+			Aggregate:          *FAggr,
 		},
 	}
 
-	var ifname string
-	if *FIface != "" {
-		ifname = *FIface
-	} else {
-		ifa, err := wifi.BestGuessWifiIface()
+	if *FNMEA != "" {
+		cnf.Location = &location.Config{Source: location.NewNMEA(*FNMEA)}
+	} else if *FGPSD != "" {
+		cnf.Location = &location.Config{Source: location.NewGPSD(*FGPSD)}
+	} else if *FLocate != 0 {
+		cnf.Location = &location.Config{UpdateInterval: *FLocate}
+	}
+
+	if *FRemCA != "" {
+		cnf.RemoteCreds, err = credentials.NewClientTLSFromFile(*FRemCA, "")
 		if err != nil {
 			log.Fatal(err)
 		}
-		ifname = ifa.Attrs().Name
+	} else if *FRemTLS {
+		cnf.RemoteCreds = credentials.NewTLS(&tls.Config{})
 	}
-	cnf.Wifi.Interface = ifname
-	cnf.Wifi.Handle, err = pcap.OpenLive(ifname, 1600, true, pcap.BlockForever)
+
+	cnf.Wifi.Overflow, err = wifi.ParseOverflowPolicy(*FOverflw)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *FTee != "" {
+		cnf.Wifi.Tee = &wifi.TeeConfig{
+			Dir:      *FTee,
+			MaxSize:  *FTeeSize << 20,
+			MaxAge:   *FTeeAge,
+			Keep:     *FTeeKeep,
+			Filtered: *FTeeFilt,
+		}
+	}
+
+	if *FTrack != "" {
+		cnf.Wifi.Location, err = location.LoadTrack(*FTrack)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *FRead != "" {
+		cnf.Wifi.Handle, err = pcap.OpenOffline(*FRead)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		listen(&cnf.Wifi)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	if *FTimeout != 0 {
 		ctx, _ = context.WithTimeout(ctx, time.Second*time.Duration(*FTimeout))
@@ -70,3 +141,53 @@ func main() {
 
 	local.Collect(ctx, cnf)
 }
+
+// live capture on -interface, hopping channels
+func listen(conf *wifi.WifiConfig) {
+	var err error
+	var ifname string
+	if *FIface != "" {
+		ifname = *FIface
+	} else {
+		ifa, err := wifi.BestGuessWifiIface()
+		if err != nil {
+			log.Fatal(err)
+		}
+		ifname = ifa.Attrs().Name
+	}
+	conf.Interface = ifname
+	conf.HopPlan, err = hopPlan()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *FAdapt {
+		conf.HopStrategy = wifi.NewAdaptive(conf.HopPlan, wifi.AdaptiveConfig{})
+	}
+	conf.Handle, err = pcap.OpenLive(ifname, 1600, true, pcap.BlockForever)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func hopPlan() (plan *wifi.HopPlan, err error) {
+	if *FHop != "" {
+		plan, err = wifi.ParseHopPlan(*FHop)
+		if err != nil {
+			return
+		}
+		return plan.Filter(*FRegdom)
+	}
+	bands, err := wifi.ParseBands(*FBands)
+	if err != nil {
+		return
+	}
+	plan, err = wifi.NewHopPlan(wifi.HopPlanConfig{
+		Bands:     bands,
+		Dwell:     *FDwell,
+		Regdomain: *FRegdom,
+	})
+	if err == nil && len(plan.Steps) == 0 {
+		err = fmt.Errorf("no channels of %v allowed in '%s'", bands, *FRegdom)
+	}
+	return
+}
@@ -2,9 +2,11 @@ package wifi
 
 import (
 	"context"
+	"fmt"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"github.com/tinygoprogs/sigint/wifi/location"
 	"log"
 	"net"
 	"runtime"
@@ -14,12 +16,58 @@ import (
 
 const LogAccountingEveryDefault = time.Minute * 1
 const DevChannelWidthDefault = 0x1000
+const MaxFixAgeDefault = time.Minute * 2
+
+// where packets come from, e.g. a *pcap.Handle, *pcapgo.Reader or
+// *pcapgo.NgReader
+type PacketSource interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
 
 type WifiConfig struct {
 	Interface          string
 	LogAccountingEvery time.Duration
 	Handle             *pcap.Handle
-	DevChannelWidth    int
+	// read from here instead of Handle, if set
+	Source          PacketSource
+	DevChannelWidth int
+	// process packets one by one as they are read, without workers, so
+	// nothing is ever dropped. For sources that can't overrun, i.e. capture
+	// files.
+	Block bool
+	// channels to hop through, DefaultHopPlan() if nil
+	HopPlan *HopPlan
+	// how to walk through the plan, in plan order if nil. A strategy brings
+	// its own plan, HopPlan is ignored then
+	HopStrategy HopStrategy
+	// where we are, DataPoints stay at 0/0 if nil
+	Location location.Locator
+	// fixes further away in time are not used, MaxFixAgeDefault if 0
+	MaxFixAge time.Duration
+	// don't try to link randomized MACs to pseudo devices
+	DisableLinker bool
+	Linker        LinkerConfig
+	// keep raw packets in rotating pcapng files, if set
+	Tee *TeeConfig
+	// don't drop uninteresting frames in the kernel, see BPFProgram. Only
+	// applies to Handle, an unfiltered Tee disables it too
+	DisableBPF bool
+	// decode packets in this many goroutines, runtime.NumCPU() if 0. Not
+	// used with Block
+	Workers int
+	// packets waiting for each worker, QueueLenDefault if 0
+	QueueLen int
+	// what to do with packets when a worker's queue is full, e.g. because
+	// the consumer of Start() is too slow
+	Overflow OverflowPolicy
+	// for OverflowSample, SampleEveryDefault if 0
+	SampleEvery int
+	// merge the DataPoints of a device over this long into one, see
+	// Aggregator. Every frame is a Device of its own if 0. The open windows
+	// are sent once capturing stopped, so keep on reading until the channel
+	// is closed
+	Aggregate time.Duration
 }
 
 type Wifi struct {
@@ -27,6 +75,11 @@ type Wifi struct {
 	cancel func()
 	ch     chan *Device
 	stats  *PacketStats
+	linker *Linker
+	hopper *Hopper
+	tee    *Tee
+	// nil if not aggregating
+	aggregator *Aggregator
 }
 
 func NewWifi(conf WifiConfig) *Wifi {
@@ -36,11 +89,37 @@ func NewWifi(conf WifiConfig) *Wifi {
 	if conf.LogAccountingEvery == 0 {
 		conf.LogAccountingEvery = LogAccountingEveryDefault
 	}
-	return &Wifi{
+	if conf.MaxFixAge == 0 {
+		conf.MaxFixAge = MaxFixAgeDefault
+	}
+	if conf.Workers == 0 {
+		conf.Workers = runtime.NumCPU()
+	}
+	if conf.QueueLen == 0 {
+		conf.QueueLen = QueueLenDefault
+	}
+	if conf.SampleEvery == 0 {
+		conf.SampleEvery = SampleEveryDefault
+	}
+	w := &Wifi{
 		WifiConfig: conf,
 		ch:         make(chan *Device, conf.DevChannelWidth),
-		stats:      NewPacketStats("total", "interesting"),
+		stats:      NewPacketStats(append([]string{"total", "interesting", "randomized", "filtered", "dropped"}, overflowStats...)...),
+		hopper:     NewHopper(conf.Interface, conf.HopPlan, nil),
 	}
+	if !conf.DisableLinker {
+		w.linker = NewLinker(conf.Linker)
+	}
+	if conf.Aggregate > 0 {
+		w.aggregator = NewAggregator(conf.Aggregate)
+	}
+	if conf.HopStrategy != nil {
+		w.hopper.SetStrategy(conf.HopStrategy)
+	}
+	w.stats.channels = func() []ChannelStat {
+		return w.hopper.Strategy().Stats()
+	}
+	return w
 }
 
 type InterestingLayers struct {
@@ -48,6 +127,14 @@ type InterestingLayers struct {
 	Dot11 *layers.Dot11
 	IEs   []*layers.Dot11InformationElement
 	Stamp time.Time
+	// what the channel hopper was tuned to at Stamp, see Hopper.TunedAt().
+	// Zero if unknown, e.g. while switching or when reading a file
+	Tuned ChannelSpec
+	// where we were at Stamp, may be nil
+	Fix *location.Location
+	// the raw packet, for the Tee
+	data []byte
+	ci   gopacket.CaptureInfo
 }
 
 // filter boring stuff like beacons, i don't care about the routers of this
@@ -59,6 +146,10 @@ func (ils *InterestingLayers) filter() bool {
 	return false
 }
 
+func (ils *InterestingLayers) isProbeRequest() bool {
+	return ils.Dot11.Type == layers.Dot11TypeMgmtProbeReq
+}
+
 // may return nil, if no device intformation is found (i.e. certain multicast packets)
 func NewInterestingLayers(p gopacket.Packet) (ils *InterestingLayers) {
 	dot11layer := p.Layer(layers.LayerTypeDot11)
@@ -81,6 +172,15 @@ func NewInterestingLayers(p gopacket.Packet) (ils *InterestingLayers) {
 		RT:    phy,
 		Dot11: dot11,
 		Stamp: p.Metadata().CaptureInfo.Timestamp,
+		data:  p.Data(),
+		ci:    p.Metadata().CaptureInfo,
+	}
+	if ils.isProbeRequest() {
+		// gopacket leaves the body of probe requests undecoded
+		if body := p.Layer(layers.LayerTypeDot11MgmtProbeReq); body != nil {
+			ils.IEs = decodeIEs(body.LayerContents())
+		}
+		return
 	}
 	all := p.Layers()
 	if len(all) >= 3 {
@@ -101,6 +201,12 @@ func (w *Wifi) logAccounting(ctx context.Context) {
 	defer log_stats.Stop()
 	logthings := func() {
 		w.stats.log()
+		if ks, err := w.KernelStats(); err != nil {
+			log.Printf("Error: %v", err)
+		} else if ks != nil {
+			log.Printf("KernelStats[received:%d dropped:%d ifdropped:%d]",
+				ks.PacketsReceived, ks.PacketsDropped, ks.PacketsIfDropped)
+		}
 		runtime.ReadMemStats(&ms)
 		log.Printf("MemStats[Alloc:%x]", ms.Alloc)
 	}
@@ -115,45 +221,145 @@ func (w *Wifi) logAccounting(ctx context.Context) {
 	}
 }
 
-// Listen for packets in w.Handle, until ctx is Done() or the source is
-// exausted
+// Listen for packets in w.Source or w.Handle, until ctx is Done() or the
+// source is exausted
 func (w *Wifi) Listen(ctx context.Context) {
-	packets := gopacket.NewPacketSource(w.Handle, w.Handle.LinkType()).Packets()
-	wg := sync.WaitGroup{}
+	if w.cancel == nil {
+		// not via Start()
+		ctx, w.cancel = context.WithCancel(ctx)
+	}
+	var src PacketSource = w.Handle
+	if w.Source != nil {
+		src = w.Source
+	} else if w.Tee != nil && !w.Tee.Filtered {
+		log.Print("not filtering in the kernel, the tee keeps every packet")
+	} else if !w.DisableBPF {
+		if err := w.installBPF(); err != nil {
+			log.Printf("Error: filtering in userspace only: %v", err)
+		}
+	}
+	if w.Tee != nil {
+		var err error
+		if w.tee, err = NewTee(*w.Tee, src.LinkType()); err != nil {
+			log.Printf("Error: not keeping raw packets: %v", err)
+		}
+	}
 	defer func() {
 		w.cancel() // ensure that our context is canceled
-		wg.Wait()  // wait for running stuff
+		if w.tee != nil {
+			if err := w.tee.Close(); err != nil {
+				log.Printf("Error: %v", err)
+			}
+		}
+		if w.aggregator != nil {
+			w.sendAll(w.aggregator.Flush())
+		}
 		close(w.ch)
 		log.Print("done")
 	}()
+	if w.aggregator != nil {
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+			w.expire(stop)
+		}()
+		// before the final Flush() above
+		defer func() {
+			close(stop)
+			<-stopped
+		}()
+	}
+	if w.Block {
+		d := newDecoder()
+		w.read(ctx, src, func(data []byte, ci gopacket.CaptureInfo) {
+			w.process(ctx, d, data, ci)
+		})
+		log.Print("packet source done")
+		return
+	}
+
+	// the reader only copies, so it keeps up with the source
+	p := newPool(w)
+	wg := sync.WaitGroup{}
+	for _, q := range p.queues {
+		wg.Add(1)
+		go func(q chan *packetBuf) {
+			defer wg.Done()
+			w.work(ctx, q)
+		}(q)
+	}
+	w.read(ctx, src, func(data []byte, ci gopacket.CaptureInfo) {
+		p.put(ctx, data, ci)
+	})
+	p.close()
+	if ctx.Err() == nil {
+		log.Print("packet source done")
+	} else {
+		log.Print("context done")
+	}
+	// the workers finish what was read, unless ctx is Done()
+	wg.Wait()
+}
+
+func (w *Wifi) handle(ctx context.Context, ils *InterestingLayers) {
+	if ils.filter() {
+		w.stats.inc("filtered")
+		return
+	}
+	dev, addr := ils.ToDevice()
+	if yes, reason := ShouldIgnore(addr); yes {
+		w.stats.ignore(reason)
+		log.Printf("skipping mac '%v' because %s", addr, reason)
+		return
+	}
+	w.stats.inc("interesting")
+	if w.tee != nil && w.tee.Filtered {
+		w.teeData(ils.ci, ils.data)
+	}
+	w.hopper.Observe(int(ils.RT.ChannelFrequency))
+	if dev.Randomized {
+		w.stats.inc("randomized")
+	}
+	if w.linker != nil {
+		w.linker.Link(dev)
+	}
+	if w.aggregator == nil {
+		w.send(ctx, dev)
+		return
+	}
+	w.sendAll(w.aggregator.Add(dev))
+}
+
+// a full channel backs up into the worker queues, see Overflow
+func (w *Wifi) send(ctx context.Context, dev *Device) {
+	select {
+	case w.ch <- dev:
+	case <-ctx.Done():
+	}
+}
+
+// Aggregated windows are gone from the Aggregator, so they are sent even
+// after ctx is Done(). Blocks until consumed, consumers read until w.ch is
+// closed, see Start().
+func (w *Wifi) sendAll(devs []*Device) {
+	for _, dev := range devs {
+		w.ch <- dev
+	}
+}
+
+// emit aggregated devices of quiet windows, until <stop> is closed
+func (w *Wifi) expire(stop <-chan struct{}) {
+	every := time.Second
+	if w.Aggregate < every {
+		every = w.Aggregate
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
 	for {
 		select {
-		case packet, ok := <-packets:
-			if !ok {
-				log.Print("packet source closed")
-				return
-			}
-			w.stats.inc("total")
-			ils := NewInterestingLayers(packet)
-			if ils == nil {
-				continue
-			}
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				if ils.filter() {
-					return
-				}
-				dev, addr := ils.ToDevice()
-				if yes, reason := ShouldIgnore(addr); yes {
-					log.Printf("skipping mac '%v' because %s", addr, reason)
-					return
-				}
-				w.stats.inc("interesting")
-				w.pushDevice(dev)
-			}()
-		case <-ctx.Done():
-			log.Print("context done")
+		case <-tick.C:
+			w.sendAll(w.aggregator.Expire())
+		case <-stop:
 			return
 		}
 	}
@@ -169,8 +375,13 @@ func (ils *InterestingLayers) ToDevice() (*Device, net.HardwareAddr) {
 	if !flags.FromDS() && !flags.ToDS() {
 		// who is the sender here? we only have one signal..
 		da, sa, bssid = dot11.Address1, dot11.Address2, dot11.Address3
-		fmt := "IBSS: currently unsupported, should return 2 deivces, da=%v, sa=%v, bssid=%v"
-		log.Printf(fmt, da, sa, bssid)
+		if ils.isProbeRequest() {
+			// a station looking for networks, exactly what we want
+			addr = sa
+		} else {
+			fmt := "IBSS: currently unsupported, should return 2 deivces, da=%v, sa=%v, bssid=%v"
+			log.Printf(fmt, da, sa, bssid)
+		}
 	} else if !flags.FromDS() && flags.ToDS() {
 		bssid, sa, da = dot11.Address1, dot11.Address2, dot11.Address3
 		addr = sa
@@ -190,31 +401,109 @@ func (ils *InterestingLayers) ToDevice() (*Device, net.HardwareAddr) {
 	}
 	//_ = bssid
 
-	return &Device{
-		MAC: addr.String(),
+	dev := &Device{
+		MAC:        addr.String(),
+		Randomized: IsLocallyAdministered(addr),
 		DataPoints: []*DataPoint{
 			&DataPoint{
 				Signal:    uint32(ils.RT.DBMAntennaSignal),
 				Frequency: uint32(ils.RT.ChannelFrequency),
 				TimeStamp: uint64(ils.Stamp.UnixNano()),
-				Location:  &Coordinates{},
+				Location:  ils.coordinates(),
+				Sequence:  uint32(dot11.SequenceNumber),
+
+				TunedFrequency: uint32(ils.Tuned.Freq()),
+				TunedChannel:   uint32(ils.Tuned.Channel),
+				ChannelWidth:   uint32(tunedWidth(ils.Tuned)),
 			},
 		},
-	}, addr
+	}
+	if ils.isProbeRequest() {
+		dev.Fingerprint = NewFingerprint(ils.IEs)
+	}
+	return dev, addr
 }
 
-func (w *Wifi) pushDevice(dev *Device) {
-	select {
-	case w.ch <- dev:
-	default:
-		log.Printf("device channel full! dropping dev: %v!", dev.MAC)
+func (ils *InterestingLayers) coordinates() *Coordinates {
+	if ils.Fix == nil {
+		return &Coordinates{}
+	}
+	return &Coordinates{
+		Lon:    ils.Fix.Lon,
+		Lat:    ils.Fix.Lat,
+		Alt:    ils.Fix.Alt,
+		Acc:    ils.Fix.Acc,
+		FixAge: uint64(ils.Fix.Age),
 	}
 }
 
-// start collecting + channel hopping + accounting
+// nil if no location source is configured or the fix is too old
+func (w *Wifi) locate(at time.Time) *location.Location {
+	if w.Location == nil {
+		return nil
+	}
+	fix := w.Location.LocationAt(at)
+	if fix == nil || fix.Age > w.MaxFixAge {
+		return nil
+	}
+	return fix
+}
+
+func tunedWidth(spec ChannelSpec) int {
+	if spec.Channel == 0 {
+		return 0
+	}
+	return spec.Width.MHz()
+}
+
+func (w *Wifi) teeData(ci gopacket.CaptureInfo, data []byte) {
+	if err := w.tee.WritePacket(ci, data); err != nil {
+		w.stats.inc("tee errors")
+		log.Printf("Error: %v", err)
+	}
+}
+
+// drop what Listen would drop in the kernel already
+func (w *Wifi) installBPF() error {
+	if w.Handle.LinkType() != layers.LinkTypeIEEE80211Radio {
+		return fmt.Errorf("no BPF program for link type %v", w.Handle.LinkType())
+	}
+	prog, err := BPFProgram()
+	if err != nil {
+		return err
+	}
+	ins, err := pcapBPF(prog)
+	if err != nil {
+		return err
+	}
+	return w.Handle.SetBPFInstructionFilter(ins)
+}
+
+// what the kernel did with our packets, nil if not reading from Handle.
+// PacketsReceived only counts what passed the BPF program.
+// Drops in userspace are PacketStats "filtered" and "dropped", see
+// OverflowPolicy.
+func (w *Wifi) KernelStats() (*pcap.Stats, error) {
+	if w.Source != nil || w.Handle == nil {
+		return nil, nil
+	}
+	return w.Handle.Stats()
+}
+
+// pin, pause or replace the plan of the channel hopping while running
+func (w *Wifi) Hopper() *Hopper {
+	return w.hopper
+}
+
+// start collecting + channel hopping + accounting, there is nothing to hop if
+// no Interface is given (i.e. reading from a file).
+// The channel has to be read until it is closed, even after ctx is Done():
+// with Aggregate the open windows are sent last.
 func (w *Wifi) Start(ctx context.Context) chan *Device {
 	ctx, w.cancel = context.WithCancel(ctx)
-	go HopChannels(ctx, w.Interface)
+	if w.Interface != "" {
+		go w.hopper.Run(ctx)
+	}
 	go w.Listen(ctx)
 	go w.logAccounting(ctx)
 	return w.ch
@@ -3,9 +3,11 @@ package wifi
 import (
 	"context"
 	"github.com/google/gopacket/pcap"
+	"github.com/tinygoprogs/sigint/wifi/location"
 	"io/ioutil"
 	"log"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -34,3 +36,44 @@ func TestIlsToDevice(t *testing.T) {
 		t.Errorf("filtering failed: ndevs > 171, ndevs=%d", ndevs)
 	}
 }
+
+func TestToDeviceRecordsTunedChannel(t *testing.T) {
+	p := probeRequest(t, "00:1b:63:00:00:01", 2412, -60)
+	ils := NewInterestingLayers(p)
+	ils.Tuned = ChannelSpec{Band: Band2GHz, Channel: 2}
+	dev, _ := ils.ToDevice()
+	dp := dev.DataPoints[0]
+	if dp.Frequency != 2412 || dp.TunedFrequency != 2417 || dp.TunedChannel != 2 || dp.ChannelWidth != 20 {
+		t.Errorf("got %v", dp)
+	}
+
+	ils.Tuned = ChannelSpec{}
+	dev, _ = ils.ToDevice()
+	dp = dev.DataPoints[0]
+	if dp.TunedFrequency != 0 || dp.TunedChannel != 0 || dp.ChannelWidth != 0 {
+		t.Errorf("unknown channel, got %v", dp)
+	}
+}
+
+func TestToDeviceStampsLocation(t *testing.T) {
+	p := probeRequest(t, "00:1b:63:00:00:01", 2412, -60)
+	stamp := time.Now()
+	p.Metadata().Timestamp = stamp
+	track := location.NewTrack(0)
+	track.Add(&location.Location{Stamp: stamp.Add(-time.Second), Lat: 48, Lon: 11, Acc: 5})
+	track.Add(&location.Location{Stamp: stamp.Add(3 * time.Second), Lat: 48.4, Lon: 11.4, Acc: 5})
+	w := NewWifi(WifiConfig{Location: track})
+
+	ils := NewInterestingLayers(p)
+	ils.Fix = w.locate(ils.Stamp)
+	dev, _ := ils.ToDevice()
+	loc := dev.DataPoints[0].Location
+	if loc.Lat != 48.1 || loc.Lon != 11.1 || loc.Acc != 5 || loc.FixAge != uint64(time.Second) {
+		t.Errorf("got %v", loc)
+	}
+
+	ils.Fix = w.locate(stamp.Add(time.Hour))
+	if ils.Fix != nil {
+		t.Errorf("too old fix used: %v", ils.Fix)
+	}
+}